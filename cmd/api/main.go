@@ -1,13 +1,30 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/isavita/codeexec/cmd/api/server"
+	"github.com/isavita/codeexec/internal/auth"
+	"github.com/isavita/codeexec/internal/egress"
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/handler"
+	"github.com/isavita/codeexec/internal/jobs"
+	"github.com/isavita/codeexec/internal/language"
+	"github.com/isavita/codeexec/internal/sessions"
 )
 
+// shutdownTimeout bounds waiting for in-flight requests on shutdown.
+const shutdownTimeout = 30 * time.Second
+
 func main() {
 	// Get the port from the environment variable or default to 8080
 	port := os.Getenv("PORT")
@@ -15,10 +32,204 @@ func main() {
 		port = "8080"
 	}
 
+	// Load the language registry, falling back to the bundled one
+	languages := language.Default()
+	if filename := os.Getenv("LANGUAGES_FILE"); filename != "" {
+		var err error
+		languages, err = language.Load(filename)
+		if err != nil {
+			log.Fatalf("Failed to load languages: %v", err)
+		}
+	}
+
+	// Keep warm sandboxes for the languages listed in POOL_SIZES, given as
+	// comma-separated name=size pairs such as "python=4,javascript=2", and
+	// check them every POOL_HEALTH_CHECK_INTERVAL, given as a duration such
+	// as "1m"
+	var opts []executor.Option
+	if sizes := os.Getenv("POOL_SIZES"); sizes != "" {
+		var poolConfig executor.PoolConfig
+		var err error
+		poolConfig.Sizes, err = parsePoolSizes(sizes)
+		if err != nil {
+			log.Fatalf("Invalid POOL_SIZES: %v", err)
+		}
+		if interval := os.Getenv("POOL_HEALTH_CHECK_INTERVAL"); interval != "" {
+			poolConfig.HealthCheckInterval, err = time.ParseDuration(interval)
+			if err == nil && poolConfig.HealthCheckInterval <= 0 {
+				err = fmt.Errorf("must be positive, got %s", interval)
+			}
+			if err != nil {
+				log.Fatalf("Invalid POOL_HEALTH_CHECK_INTERVAL: %v", err)
+			}
+		}
+		opts = append(opts, executor.WithPool(poolConfig))
+	}
+
+	// Run sandboxes under the OCI runtime in SANDBOX_RUNTIME, such as runsc
+	// for gVisor, unless their language configures its own
+	if runtime := os.Getenv("SANDBOX_RUNTIME"); runtime != "" {
+		opts = append(opts, executor.WithRuntime(runtime))
+	}
+
+	// Serve the egress proxy for sandboxes with network access, if
+	// configured. EGRESS_NETWORK names an internal Docker network from which
+	// the proxy is reachable at EGRESS_PROXY_URL
+	if network := os.Getenv("EGRESS_NETWORK"); network != "" {
+		proxy := egress.NewProxy(false)
+		addr := os.Getenv("EGRESS_PROXY_ADDR")
+		if addr == "" {
+			addr = ":3128"
+		}
+		go func() {
+			log.Printf("Egress proxy listening on %s", addr)
+			log.Fatal(http.ListenAndServe(addr, proxy))
+		}()
+
+		var allowedHosts []string
+		if hosts := os.Getenv("EGRESS_ALLOWED_HOSTS"); hosts != "" {
+			allowedHosts = strings.Split(hosts, ",")
+		}
+		opts = append(opts, executor.WithEgress(executor.EgressConfig{
+			Network:      network,
+			ProxyURL:     os.Getenv("EGRESS_PROXY_URL"),
+			Proxy:        proxy,
+			AllowedHosts: allowedHosts,
+		}))
+	}
+
+	// Create the Docker execution backend
+	exec, err := executor.NewDockerExecutor(languages, opts...)
+	if err != nil {
+		log.Fatalf("Failed to create Docker executor: %v", err)
+	}
+	defer exec.Close()
+
+	// Load the API keys and their maximum limits, if configured
+	var serverOpts []server.Option
+	if filename := os.Getenv("API_KEYS_FILE"); filename != "" {
+		keys, err := auth.Load(filename)
+		if err != nil {
+			log.Fatalf("Failed to load API keys: %v", err)
+		}
+		serverOpts = append(serverOpts, server.WithAPIKeys(keys))
+	}
+
+	// Run asynchronous jobs on JOB_WORKERS workers, with up to
+	// JOB_QUEUE_SIZE jobs waiting
+	jobConfig := jobs.Config{}
+	if workers := os.Getenv("JOB_WORKERS"); workers != "" {
+		jobConfig.Workers, err = strconv.Atoi(workers)
+		if err != nil {
+			log.Fatalf("Invalid JOB_WORKERS: %v", err)
+		}
+	}
+	if size := os.Getenv("JOB_QUEUE_SIZE"); size != "" {
+		jobConfig.QueueSize, err = strconv.Atoi(size)
+		if err != nil {
+			log.Fatalf("Invalid JOB_QUEUE_SIZE: %v", err)
+		}
+	}
+
+	// Sign the callbacks of finished jobs with CALLBACK_SECRET, making up to
+	// CALLBACK_MAX_ATTEMPTS attempts at delivering each. Callbacks to private
+	// addresses are refused unless CALLBACK_ALLOW_PRIVATE is set
+	jobConfig.Webhooks.Secret = []byte(os.Getenv("CALLBACK_SECRET"))
+	if allow := os.Getenv("CALLBACK_ALLOW_PRIVATE"); allow != "" {
+		jobConfig.Webhooks.AllowPrivate, err = strconv.ParseBool(allow)
+		if err != nil {
+			log.Fatalf("Invalid CALLBACK_ALLOW_PRIVATE: %v", err)
+		}
+	}
+	if attempts := os.Getenv("CALLBACK_MAX_ATTEMPTS"); attempts != "" {
+		jobConfig.Webhooks.MaxAttempts, err = strconv.Atoi(attempts)
+		if err != nil {
+			log.Fatalf("Invalid CALLBACK_MAX_ATTEMPTS: %v", err)
+		}
+	}
+	queue := jobs.New(exec, jobConfig)
+	defer queue.Close()
+	serverOpts = append(serverOpts, server.WithJobs(queue))
+
+	// Bound interactive sessions by INTERACTIVE_IDLE_TIMEOUT and
+	// INTERACTIVE_MAX_DURATION, given as durations such as "5m", and let the
+	// web pages at INTERACTIVE_ALLOWED_ORIGINS open them
+	var interactiveConfig handler.InteractiveConfig
+	if timeout := os.Getenv("INTERACTIVE_IDLE_TIMEOUT"); timeout != "" {
+		interactiveConfig.IdleTimeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatalf("Invalid INTERACTIVE_IDLE_TIMEOUT: %v", err)
+		}
+	}
+	if duration := os.Getenv("INTERACTIVE_MAX_DURATION"); duration != "" {
+		interactiveConfig.MaxDuration, err = time.ParseDuration(duration)
+		if err != nil {
+			log.Fatalf("Invalid INTERACTIVE_MAX_DURATION: %v", err)
+		}
+	}
+	if origins := os.Getenv("INTERACTIVE_ALLOWED_ORIGINS"); origins != "" {
+		interactiveConfig.AllowedOrigins = strings.Split(origins, ",")
+	}
+	serverOpts = append(serverOpts, server.WithInteractive(interactiveConfig))
+
+	// Delete stateful sessions idle for SESSION_IDLE_TIMEOUT, given as a
+	// duration such as "30m", and keep at most MAX_SESSIONS open
+	var sessionConfig sessions.Config
+	if timeout := os.Getenv("SESSION_IDLE_TIMEOUT"); timeout != "" {
+		sessionConfig.IdleTimeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatalf("Invalid SESSION_IDLE_TIMEOUT: %v", err)
+		}
+	}
+	if count := os.Getenv("MAX_SESSIONS"); count != "" {
+		sessionConfig.MaxSessions, err = strconv.Atoi(count)
+		if err != nil {
+			log.Fatalf("Invalid MAX_SESSIONS: %v", err)
+		}
+	}
+	sessionManager := sessions.New(exec, sessionConfig)
+	defer sessionManager.Close()
+	serverOpts = append(serverOpts, server.WithSessions(sessionManager))
+
 	// Create a new API server
-	srv := server.NewServer()
+	srv := &http.Server{Addr: ":" + port, Handler: server.NewServer(exec, languages, serverOpts...)}
+
+	// Stop accepting requests on SIGINT or SIGTERM so that the pool's
+	// sandboxes are removed before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	shutdown := make(chan struct{})
+	go func() {
+		defer close(shutdown)
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down cleanly: %v", err)
+		}
+	}()
 
 	// Start the HTTP server
 	log.Printf("Server listening on :%s", port)
-	log.Fatal(http.ListenAndServe(":"+port, srv))
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Print(err)
+		return
+	}
+	<-shutdown
+}
+
+func parsePoolSizes(value string) (map[string]int, error) {
+	sizes := make(map[string]int)
+	for _, pair := range strings.Split(value, ",") {
+		name, size, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return nil, fmt.Errorf("expected name=size, got %q", pair)
+		}
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size for %s: %w", name, err)
+		}
+		sizes[name] = n
+	}
+	return sizes, nil
 }
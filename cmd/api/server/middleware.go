@@ -4,12 +4,32 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/isavita/codeexec/internal/auth"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
+// AuthMiddleware rejects requests without a valid X-Api-Key header. When
+// keys are configured, the request must carry one of them and the matching
+// key is stored in the request context. Otherwise the single key in API_KEY
+// is checked if API_KEY_CHECK_ENABLED is "true". Since browsers cannot set
+// headers on WebSocket handshakes, those may pass the key in the api_key
+// query parameter instead.
+func AuthMiddleware(keys *auth.Keys, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if os.Getenv("API_KEY_CHECK_ENABLED") == "true" {
-			apiKey := r.Header.Get("X-Api-Key")
+		apiKey := r.Header.Get("X-Api-Key")
+		if apiKey == "" && websocket.IsWebSocketUpgrade(r) {
+			apiKey = r.URL.Query().Get("api_key")
+		}
+		if keys != nil {
+			key, ok := keys.Lookup(apiKey)
+			if !ok {
+				errorResponse(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			r = r.WithContext(auth.NewContext(r.Context(), key))
+		} else if os.Getenv("API_KEY_CHECK_ENABLED") == "true" {
 			expectedApiKey := os.Getenv("API_KEY")
 			if expectedApiKey == "" {
 				errorResponse(w, "API key not set", http.StatusInternalServerError)
@@ -3,14 +3,86 @@ package server
 import (
 	"net/http"
 
+	"github.com/isavita/codeexec/internal/auth"
+	"github.com/isavita/codeexec/internal/executor"
 	"github.com/isavita/codeexec/internal/handler"
+	"github.com/isavita/codeexec/internal/jobs"
+	"github.com/isavita/codeexec/internal/language"
+	"github.com/isavita/codeexec/internal/sessions"
 )
 
-func NewServer() http.Handler {
-	codeExecutionHandler := handler.NewCodeExecutionHandler()
+// Option configures the server.
+type Option func(*config)
+
+type config struct {
+	keys        *auth.Keys
+	queue       *jobs.Queue
+	interactive handler.InteractiveConfig
+	sessions    *sessions.Manager
+}
+
+// WithAPIKeys authenticates requests against keys instead of the API_KEY
+// environment variable, applying each key's maximum limits.
+func WithAPIKeys(keys *auth.Keys) Option {
+	return func(c *config) {
+		c.keys = keys
+	}
+}
+
+// WithJobs serves the asynchronous job API under /api/jobs, running jobs on
+// the queue.
+func WithJobs(queue *jobs.Queue) Option {
+	return func(c *config) {
+		c.queue = queue
+	}
+}
+
+// WithInteractive configures the interactive sessions served under
+// /api/interactive when the executor supports them.
+func WithInteractive(interactive handler.InteractiveConfig) Option {
+	return func(c *config) {
+		c.interactive = interactive
+	}
+}
+
+// WithSessions serves stateful sessions under /api/sessions, keeping them in
+// manager.
+func WithSessions(manager *sessions.Manager) Option {
+	return func(c *config) {
+		c.sessions = manager
+	}
+}
+
+func NewServer(exec executor.Executor, languages *language.Registry, opts ...Option) http.Handler {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	codeExecutionHandler := handler.NewCodeExecutionHandler(exec, languages)
 
 	mux := http.NewServeMux()
-	mux.Handle("/api/execute", AuthMiddleware(codeExecutionHandler))
+	mux.Handle("/api/execute", AuthMiddleware(cfg.keys, codeExecutionHandler))
+	mux.Handle("POST /api/execute/stream", AuthMiddleware(cfg.keys, handler.NewStreamHandler(exec, languages)))
+	mux.Handle("POST /api/judge", AuthMiddleware(cfg.keys, handler.NewJudgeHandler(exec, languages)))
+	if sessions, ok := exec.(executor.SessionExecutor); ok {
+		mux.Handle("GET /api/interactive", AuthMiddleware(cfg.keys, handler.NewInteractiveHandler(sessions, languages, cfg.interactive)))
+	}
+
+	if cfg.queue != nil {
+		jobsHandler := handler.NewJobsHandler(cfg.queue, languages)
+		mux.Handle("POST /api/jobs", AuthMiddleware(cfg.keys, http.HandlerFunc(jobsHandler.Submit)))
+		mux.Handle("GET /api/jobs/{id}", AuthMiddleware(cfg.keys, http.HandlerFunc(jobsHandler.Status)))
+		mux.Handle("DELETE /api/jobs/{id}", AuthMiddleware(cfg.keys, http.HandlerFunc(jobsHandler.Cancel)))
+	}
+
+	if cfg.sessions != nil {
+		sessionsHandler := handler.NewSessionsHandler(cfg.sessions, languages)
+		mux.Handle("POST /api/sessions", AuthMiddleware(cfg.keys, http.HandlerFunc(sessionsHandler.Create)))
+		mux.Handle("GET /api/sessions/{id}", AuthMiddleware(cfg.keys, http.HandlerFunc(sessionsHandler.Status)))
+		mux.Handle("POST /api/sessions/{id}/execute", AuthMiddleware(cfg.keys, http.HandlerFunc(sessionsHandler.Execute)))
+		mux.Handle("DELETE /api/sessions/{id}", AuthMiddleware(cfg.keys, http.HandlerFunc(sessionsHandler.Delete)))
+	}
 
 	return mux
 }
@@ -0,0 +1,7 @@
+FROM alpine:3.19
+
+# Install the C++ toolchain
+RUN apk add --no-cache build-base
+
+# Set the working directory
+WORKDIR /app
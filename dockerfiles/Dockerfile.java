@@ -0,0 +1,4 @@
+FROM eclipse-temurin:21-jdk-alpine
+
+# Set the working directory
+WORKDIR /app
@@ -2,14 +2,17 @@ module github.com/isavita/codeexec
 
 go 1.22.2
 
-require github.com/docker/docker v25.0.0+incompatible
+require (
+	github.com/docker/docker v25.0.0+incompatible
+	github.com/docker/go-units v0.5.0
+	github.com/gorilla/websocket v1.5.3
+)
 
 require (
 	github.com/Microsoft/go-winio v0.6.1 // indirect
 	github.com/containerd/log v0.1.0 // indirect
 	github.com/distribution/reference v0.6.0 // indirect
 	github.com/docker/go-connections v0.5.0 // indirect
-	github.com/docker/go-units v0.5.0 // indirect
 	github.com/felixge/httpsnoop v1.0.4 // indirect
 	github.com/go-logr/logr v1.4.1 // indirect
 	github.com/go-logr/stdr v1.2.2 // indirect
@@ -0,0 +1,122 @@
+// Package auth holds the API keys accepted by the server, each with the
+// maximum limits and the network access its requests may ask for.
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/isavita/codeexec/internal/egress"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// Key is an API key accepted by the server.
+type Key struct {
+	// Name identifies the key's owner in logs; the key itself is never
+	// logged.
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	// MaxLimits caps the limits requests made with this key may ask for,
+	// below each language's own maximum. Zero fields leave the language's
+	// maximum in place.
+	MaxLimits language.Limits `json:"max_limits"`
+	// Network, when set, lets requests made with this key ask for network
+	// access. Without it they cannot.
+	Network *NetworkPolicy `json:"network,omitempty"`
+}
+
+// NetworkPolicy is the network access an API key may grant.
+type NetworkPolicy struct {
+	// AllowedHosts are the host patterns requests may ask to reach.
+	AllowedHosts []string `json:"allowed_hosts"`
+	// Default grants access to all of AllowedHosts to requests that do not
+	// ask for network access themselves.
+	Default bool `json:"default,omitempty"`
+}
+
+// Keys is a read-only set of API keys.
+type Keys struct {
+	keys []*Key
+}
+
+type config struct {
+	Keys []*Key `json:"keys"`
+}
+
+// Load reads the keys from a JSON config file.
+func Load(filename string) (*Keys, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys: %w", err)
+	}
+	keys, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API keys file %s: %w", filename, err)
+	}
+	return keys, nil
+}
+
+// Parse builds a key set from the JSON representation of a config file.
+func Parse(data []byte) (*Keys, error) {
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Keys) == 0 {
+		return nil, errors.New("no keys configured")
+	}
+
+	names := make(map[string]bool, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		switch {
+		case key.Name == "":
+			return nil, errors.New("key without a name")
+		case names[key.Name]:
+			return nil, fmt.Errorf("key %q configured more than once", key.Name)
+		case key.Key == "":
+			return nil, fmt.Errorf("key %q: key not set", key.Name)
+		}
+		limits := key.MaxLimits
+		if limits.Timeout < 0 || limits.MemoryMB < 0 || limits.CPUs < 0 || limits.Pids < 0 || limits.OutputBytes < 0 {
+			return nil, fmt.Errorf("key %q: max_limits must not be negative", key.Name)
+		}
+		if key.Network != nil {
+			for _, pattern := range key.Network.AllowedHosts {
+				if err := egress.ValidatePattern(pattern); err != nil {
+					return nil, fmt.Errorf("key %q: %w", key.Name, err)
+				}
+			}
+		}
+		names[key.Name] = true
+	}
+	return &Keys{keys: cfg.Keys}, nil
+}
+
+// Lookup returns the key matching apiKey. Every key is compared in constant
+// time so that timing does not reveal partial matches.
+func (k *Keys) Lookup(apiKey string) (*Key, bool) {
+	var found *Key
+	for _, key := range k.keys {
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(apiKey)) == 1 {
+			found = key
+		}
+	}
+	return found, found != nil
+}
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the key a request was made with.
+func NewContext(ctx context.Context, key *Key) context.Context {
+	return context.WithValue(ctx, contextKey{}, key)
+}
+
+// FromContext returns the key stored in ctx by NewContext.
+func FromContext(ctx context.Context) (*Key, bool) {
+	key, ok := ctx.Value(contextKey{}).(*Key)
+	return key, ok
+}
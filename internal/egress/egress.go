@@ -0,0 +1,292 @@
+// Package egress implements the forward proxy through which sandboxes with
+// network access reach the outside world. Each execution is granted a token
+// that only opens connections to its allowlisted hosts.
+package egress
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// dialTimeout bounds connecting to an upstream host.
+const dialTimeout = 10 * time.Second
+
+// hopHeaders are the headers that apply to a single connection and are not
+// forwarded upstream.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// Proxy is an HTTP forward proxy supporting plain requests and CONNECT
+// tunnels. Clients authenticate with the token of their grant as the proxy
+// user name, which HTTP_PROXY URLs such as http://token@proxy:3128 do.
+type Proxy struct {
+	dialer    *net.Dialer
+	transport *http.Transport
+
+	mu     sync.Mutex
+	grants map[string][]string
+}
+
+// NewProxy returns a proxy without grants. Unless allowPrivate is set,
+// connections to loopback, private and link-local addresses are refused
+// whatever the allowlist says, so that sandboxes cannot reach the host or its
+// network through a name resolving to such an address.
+func NewProxy(allowPrivate bool) *Proxy {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if !allowPrivate {
+		dialer.Control = RefusePrivate
+	}
+	return &Proxy{
+		dialer: dialer,
+		transport: &http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		},
+		grants: make(map[string][]string),
+	}
+}
+
+// Grant returns a token allowing connections to the hosts matching
+// allowedHosts, and a function revoking it.
+func (p *Proxy) Grant(allowedHosts []string) (token string, revoke func()) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("egress: failed to generate token: %v", err))
+	}
+	token = hex.EncodeToString(b)
+
+	p.mu.Lock()
+	p.grants[token] = append([]string(nil), allowedHosts...)
+	p.mu.Unlock()
+
+	return token, func() {
+		p.mu.Lock()
+		delete(p.grants, token)
+		p.mu.Unlock()
+	}
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	allowedHosts, ok := p.authenticate(r)
+	if !ok {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="codeexec"`)
+		http.Error(w, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	// CONNECT requests carry host:port in place of a URL, which is parsed
+	// into URL.Host as well
+	host := r.URL.Hostname()
+	if host == "" {
+		http.Error(w, "absolute URL required", http.StatusBadRequest)
+		return
+	}
+	if !Allowed(allowedHosts, host) {
+		http.Error(w, fmt.Sprintf("host %s is not allowed", host), http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+	} else {
+		p.forward(w, r)
+	}
+}
+
+// authenticate returns the allowlist of the grant named in the
+// Proxy-Authorization header.
+func (p *Proxy) authenticate(r *http.Request) ([]string, bool) {
+	scheme, credentials, ok := strings.Cut(r.Header.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return nil, false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(credentials)
+	if err != nil {
+		return nil, false
+	}
+	token, _, _ := strings.Cut(string(decoded), ":")
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	allowedHosts, ok := p.grants[token]
+	return allowedHosts, ok
+}
+
+// tunnel connects the client to the requested host:port and copies bytes in
+// both directions until either side closes.
+func (p *Proxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dialer.DialContext(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "tunnelling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		log.Printf("Failed to hijack proxy connection: %v", err)
+		return
+	}
+	defer client.Close()
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n")); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		// Bytes the client sent after the CONNECT request may be buffered
+		io.Copy(upstream, buffered)
+		closeWrite(upstream)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream)
+		closeWrite(client)
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+// forward sends a plain HTTP request upstream and copies the response back.
+func (p *Proxy) forward(w http.ResponseWriter, r *http.Request) {
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	for _, header := range hopHeaders {
+		out.Header.Del(header)
+	}
+
+	resp, err := p.transport.RoundTrip(out)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range hopHeaders {
+		resp.Header.Del(header)
+	}
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		c.CloseWrite()
+	}
+}
+
+// RefusePrivate is a net.Dialer Control function refusing addresses that do
+// not belong to the public internet. Webhook deliveries use it as well.
+func RefusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("connections to %s are not allowed", host)
+	}
+	return nil
+}
+
+// ValidatePattern checks a host pattern: a host name or IPv4 address, a
+// wildcard "*.example.com" matching every subdomain of example.com, or "*"
+// matching every host.
+func ValidatePattern(pattern string) error {
+	host := strings.TrimPrefix(pattern, "*.")
+	switch {
+	case pattern == "*":
+		return nil
+	case host == "" || strings.ContainsAny(host, "*/:@ "):
+		return fmt.Errorf("invalid host pattern %q", pattern)
+	}
+	return nil
+}
+
+// Allowed reports whether host matches one of the patterns.
+func Allowed(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		switch {
+		case pattern == "*" || pattern == host:
+			return true
+		case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// Covers reports whether every host matching pattern also matches one of
+// the patterns, so that a grant for pattern stays within them.
+func Covers(patterns []string, pattern string) bool {
+	if !strings.HasPrefix(pattern, "*") {
+		return Allowed(patterns, pattern)
+	}
+	pattern = strings.ToLower(pattern)
+	for _, p := range patterns {
+		p = strings.ToLower(p)
+		switch {
+		case p == "*" || p == pattern:
+			return true
+		case pattern != "*" && strings.HasPrefix(p, "*.") && strings.HasSuffix(pattern[1:], p[1:]):
+			return true
+		}
+	}
+	return false
+}
+
+// ErrNotPermitted is returned by Check for hosts outside the permitted
+// patterns.
+var ErrNotPermitted = errors.New("host not permitted")
+
+// Check validates the requested patterns and verifies that each is covered
+// by the permitted ones.
+func Check(permitted, requested []string) error {
+	for _, pattern := range requested {
+		if err := ValidatePattern(pattern); err != nil {
+			return err
+		}
+		if !Covers(permitted, pattern) {
+			return fmt.Errorf("%w: %s", ErrNotPermitted, pattern)
+		}
+	}
+	return nil
+}
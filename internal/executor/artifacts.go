@@ -0,0 +1,139 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unicode/utf8"
+)
+
+const (
+	// maxArtifacts bounds the number of files returned as artifacts. Further
+	// files are not listed at all.
+	maxArtifacts = 20
+	// maxArtifactSize is the largest file whose content is returned.
+	maxArtifactSize = 1 << 20
+	// maxArtifactsSize bounds the total content returned for all artifacts.
+	maxArtifactsSize = 5 << 20
+)
+
+// Artifact is a file created or modified by the program in its working
+// directory. Content is inline text for UTF-8 files and base64 otherwise; it
+// is omitted for files over the size caps.
+type Artifact struct {
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Encoding string `json:"encoding,omitempty"`
+	Content  string `json:"content,omitempty"`
+	Omitted  bool   `json:"omitted,omitempty"`
+}
+
+// Snapshot records the content hash of every regular file in a workspace.
+type Snapshot map[string][sha256.Size]byte
+
+// SnapshotWorkspace hashes the regular files below dir.
+func SnapshotWorkspace(dir string) (Snapshot, error) {
+	snapshot := make(Snapshot)
+	err := walkWorkspace(dir, func(name, path string, info fs.FileInfo) error {
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		snapshot[name] = sum
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot workspace: %w", err)
+	}
+	return snapshot, nil
+}
+
+// CollectArtifacts returns the regular files below dir that are new or
+// changed since the snapshot was taken, in walk order. Only the first
+// maxArtifacts files are returned, and it reports whether there were more.
+// Symlinks and other special files are ignored, so that a program cannot
+// make the server read files outside its workspace.
+func CollectArtifacts(dir string, before Snapshot) ([]Artifact, bool, error) {
+	var artifacts []Artifact
+	var total int64
+	truncated := false
+	err := walkWorkspace(dir, func(name, path string, info fs.FileInfo) error {
+		sum, err := hashFile(path)
+		if err != nil {
+			return err
+		}
+		if previous, ok := before[name]; ok && previous == sum {
+			return nil
+		}
+		if len(artifacts) >= maxArtifacts {
+			truncated = true
+			return fs.SkipAll
+		}
+
+		artifact := Artifact{Path: name, Size: info.Size()}
+		if info.Size() > maxArtifactSize || total+info.Size() > maxArtifactsSize {
+			artifact.Omitted = true
+		} else {
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			total += int64(len(content))
+			if utf8.Valid(content) {
+				artifact.Encoding = "utf-8"
+				artifact.Content = string(content)
+			} else {
+				artifact.Encoding = "base64"
+				artifact.Content = base64.StdEncoding.EncodeToString(content)
+			}
+		}
+		artifacts = append(artifacts, artifact)
+		return nil
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to collect artifacts: %w", err)
+	}
+	return artifacts, truncated, nil
+}
+
+// walkWorkspace calls fn for every regular file below dir with its
+// slash-separated path relative to dir.
+func walkWorkspace(dir string, fn func(name, path string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		return fn(filepath.ToSlash(name), path, info)
+	})
+}
+
+func hashFile(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
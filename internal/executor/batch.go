@@ -0,0 +1,195 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// Run is one run of a batch: the program's input and the limits it runs
+// under.
+type Run struct {
+	Stdin string
+	// Limits override the request's limits for this run. Zero fields select
+	// the request's.
+	Limits language.Limits
+}
+
+// BatchResult describes the outcome of a batch.
+type BatchResult struct {
+	// SyntaxCheck and Compile report the checks of the submission, as in
+	// Result.
+	SyntaxCheck SyntaxCheck    `json:"syntax_check"`
+	Compile     *CompileResult `json:"compile,omitempty"`
+	// Runs holds a result per run, in order, unless the submission was
+	// rejected by its syntax check or compiler.
+	Runs []*Result `json:"runs"`
+	// WallTimeMs is the wall-clock time of the whole batch.
+	WallTimeMs int64 `json:"wall_time_ms"`
+}
+
+// BatchExecutor is implemented by executors that can run a program
+// repeatedly without setting up a sandbox for every run.
+type BatchExecutor interface {
+	// ExecuteBatch prepares a submission once, as Execute does, then runs
+	// its program once per run with the run's stdin and limits, each run
+	// timed and limited on its own. The request's stdin and Output are
+	// ignored, and no artifacts are collected.
+	ExecuteBatch(ctx context.Context, req Request, runs []Run) (*BatchResult, error)
+}
+
+// ExecuteEach runs a batch on an executor without batches, executing the
+// request once per run.
+func ExecuteEach(ctx context.Context, exec Executor, req Request, runs []Run) (*BatchResult, error) {
+	start := time.Now()
+	batch := &BatchResult{Runs: make([]*Result, 0, len(runs))}
+	for i, run := range runs {
+		runReq := req
+		runReq.Stdin = run.Stdin
+		runReq.Limits = req.Limits.Override(run.Limits)
+		result, err := exec.Execute(ctx, runReq)
+		if err != nil {
+			return nil, fmt.Errorf("run %d: %w", i+1, err)
+		}
+		if i == 0 {
+			batch.SyntaxCheck = result.SyntaxCheck
+			batch.Compile = result.Compile
+		}
+		if !result.SyntaxCheck.Passed {
+			// Every run would be rejected the same way
+			batch.Runs = nil
+			break
+		}
+		batch.Runs = append(batch.Runs, result)
+	}
+	batch.WallTimeMs = time.Since(start).Milliseconds()
+	return batch, nil
+}
+
+// resetCommand kills the processes left behind by a run and empties the
+// working directory and /tmp. It runs as the sandbox user, so it removes
+// what the program created but not the root-owned files copied in, which
+// the program cannot have changed. It exits with 1 when processes other than
+// the idle one remain: the idle process does not reap the killed ones, which
+// stay zombies holding on to the pids limit.
+var resetCommand = []string{"sh", "-c", "kill -9 -1 2>/dev/null; chmod -R u+rwX /app /tmp 2>/dev/null; rm -rf /app/* /app/.[!.]* /app/..?* /tmp/* /tmp/.[!.]* /tmp/..?* 2>/dev/null; for p in /proc/[0-9]*; do case ${p#/proc/} in 1|$$) ;; *) exit 1 ;; esac; done"}
+
+var _ BatchExecutor = (*DockerExecutor)(nil)
+
+// ExecuteBatch runs every run in the same sandbox, so that the sandbox is
+// created and the program compiled only once. Between runs, the sandbox is
+// reset: the processes a run left behind are killed, /tmp is emptied and the
+// working directory is restored to its state before the first run, so that
+// runs neither see each other's files nor share their resource usage. A run
+// that times out, leaves processes behind or takes the sandbox down gets the
+// following runs a new sandbox, seeded with the prepared files rather than
+// compiling the program again. This is the expected path for programs that
+// start processes outliving them: once killed, these stay zombies of the
+// sandbox's idle process, which does not reap them, and would hold on to
+// the pids of the following runs.
+func (e *DockerExecutor) ExecuteBatch(ctx context.Context, req Request, runs []Run) (*BatchResult, error) {
+	start := time.Now()
+
+	lang, ok := e.languages.Lookup(req.Language)
+	if !ok {
+		return nil, fmt.Errorf("unsupported language: %s", req.Language)
+	}
+	limits := lang.Limits.Override(req.Limits)
+
+	sb, release, err := e.acquireSandbox(ctx, lang, req.Network)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { release() }()
+
+	prepared := &Result{SyntaxCheck: SyntaxCheck{Passed: true}}
+	entrypoint, ok, err := prepare(ctx, sb, lang, limits, req, prepared)
+	if err != nil {
+		return nil, err
+	}
+	batch := &BatchResult{SyntaxCheck: prepared.SyntaxCheck, Compile: prepared.Compile}
+	if !ok {
+		batch.WallTimeMs = time.Since(start).Milliseconds()
+		return batch, nil
+	}
+	cmd := expandCommand(lang.Run, entrypoint)
+
+	batch.Runs = make([]*Result, 0, len(runs))
+	broken := false
+	for i, run := range runs {
+		if i > 0 && !broken {
+			clean, err := sb.reset(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("run %d: %w", i+1, err)
+			}
+			broken = !clean
+		}
+		if broken {
+			sb, release, err = e.replaceSandbox(ctx, lang, req.Network, sb, release)
+			if err != nil {
+				return nil, fmt.Errorf("run %d: %w", i+1, err)
+			}
+		}
+
+		runLimits := limits.Override(run.Limits)
+		spec := execSpec{cmd: cmd, limits: runLimits}
+		if run.Stdin != "" {
+			spec.stdin = strings.NewReader(run.Stdin)
+		}
+		execRun, err := sb.exec(ctx, spec)
+		if err != nil {
+			return nil, fmt.Errorf("run %d: %w", i+1, err)
+		}
+		result := &Result{
+			SyntaxCheck: batch.SyntaxCheck,
+			Compile:     batch.Compile,
+			Limits:      resultLimits(runLimits),
+			Runtime:     sb.runtime,
+		}
+		recordRun(lang, execRun, result)
+		result.WallTimeMs = result.Timings.RunMs
+		batch.Runs = append(batch.Runs, result)
+
+		// The program may also have taken the sandbox's idle process down,
+		// for instance by exhausting its memory
+		broken = execRun.timedOut || !sb.healthy(ctx)
+	}
+	batch.WallTimeMs = time.Since(start).Milliseconds()
+	return batch, nil
+}
+
+// replaceSandbox replaces a sandbox a run took down with a new one, seeded
+// with its files, which hold the build outputs of compiled languages, so that
+// the program is not compiled again. The sandbox returned is to be released
+// by the caller, even along with an error.
+func (e *DockerExecutor) replaceSandbox(ctx context.Context, lang *language.Language, network *Network, old *sandbox, releaseOld func()) (*sandbox, func(), error) {
+	sb, release, err := e.acquireSandbox(ctx, lang, network)
+	if err != nil {
+		return old, releaseOld, err
+	}
+	// The old sandbox removes the new one's empty directory instead
+	sb.dir, old.dir = old.dir, sb.dir
+	releaseOld()
+	return sb, release, sb.copyIn(ctx)
+}
+
+// reset restores the sandbox to its state after the submission was prepared,
+// from the files of its host directory, which hold the build outputs of
+// compiled languages. It reports false, leaving the sandbox as it is, when
+// processes of the previous run could not be got rid of.
+func (sb *sandbox) reset(ctx context.Context) (bool, error) {
+	run, err := sb.exec(ctx, execSpec{cmd: resetCommand, limits: sb.copyLimits(), noStats: true})
+	if err != nil {
+		return false, fmt.Errorf("failed to reset sandbox: %w", err)
+	}
+	if run.timedOut {
+		return false, fmt.Errorf("failed to reset sandbox: timed out after %s", copyTimeout)
+	}
+	if run.exitCode != 0 {
+		return false, nil
+	}
+	return true, sb.copyIn(ctx)
+}
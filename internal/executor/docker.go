@@ -2,259 +2,489 @@ package executor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
-	"os"
-	"path/filepath"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
-	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/docker/errdefs"
+
+	"github.com/isavita/codeexec/internal/egress"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+var _ Executor = (*DockerExecutor)(nil)
+
+const (
+	// workDir is where the submitted code is copied inside sandboxes.
+	workDir = "/app"
+	// syntaxCheckTimeout bounds the pre-execution syntax check.
+	syntaxCheckTimeout = 10 * time.Second
+	// cleanupTimeout bounds killing and removing a container once the
+	// execution is over.
+	cleanupTimeout = 10 * time.Second
+	// infoTimeout bounds querying the daemon's runtimes on startup.
+	infoTimeout = 10 * time.Second
 )
 
+// DockerExecutor runs each submission in its own sandbox container, using
+// the image and commands configured for its language. Sandboxes are taken
+// from a warm pool when one is configured.
 type DockerExecutor struct {
-	client *client.Client
+	client     *client.Client
+	languages  *language.Registry
+	poolConfig *PoolConfig
+	pool       *pool
+	egress     *EgressConfig
+	// runtime is the OCI runtime of languages without their own. It is the
+	// daemon's default unless set through WithRuntime.
+	runtime string
 }
 
-func NewDockerExecutor() (*DockerExecutor, error) {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
-	if err != nil {
-		return nil, err
+// Option configures a DockerExecutor.
+type Option func(*DockerExecutor)
+
+// WithPool keeps idle sandboxes ready for the configured languages.
+func WithPool(config PoolConfig) Option {
+	return func(e *DockerExecutor) {
+		e.poolConfig = &config
 	}
-	return &DockerExecutor{client: cli}, nil
 }
 
-func (e *DockerExecutor) Execute(code, language string, timeout time.Duration) (string, error) {
-	if err := e.syntaxCheck(code, language); err != nil {
-		return "", fmt.Errorf("syntax check failed: %v", err)
+// WithRuntime runs sandboxes under the named OCI runtime, such as runsc for
+// gVisor or kata-runtime for Kata Containers, unless their language
+// configures its own.
+func WithRuntime(name string) Option {
+	return func(e *DockerExecutor) {
+		e.runtime = name
 	}
+}
 
-	containerID, err := e.createContainer(code, language)
-	if err != nil {
-		return "", fmt.Errorf("failed to create container: %v", err)
+// EgressConfig routes the traffic of sandboxes with network access through
+// an egress proxy enforcing each execution's allowed hosts.
+type EgressConfig struct {
+	// Network is the Docker network such sandboxes join. It should be
+	// created with --internal, so that the proxy is their only way out.
+	Network string
+	// ProxyURL is the address of Proxy as seen from Network, such as
+	// http://172.30.0.1:3128. Sandboxes find it in HTTP_PROXY and HTTPS_PROXY.
+	ProxyURL string
+	Proxy    *egress.Proxy
+	// AllowedHosts are the host patterns any execution may be granted.
+	AllowedHosts []string
+}
+
+func (c *EgressConfig) validate() error {
+	switch {
+	case c.Network == "":
+		return errors.New("egress: network not set")
+	case c.Proxy == nil:
+		return errors.New("egress: proxy not set")
+	}
+	u, err := url.Parse(c.ProxyURL)
+	if err != nil || u.Scheme != "http" || u.Host == "" {
+		return fmt.Errorf("egress: proxy URL must be an http:// URL, got %q", c.ProxyURL)
 	}
-	defer e.removeContainer(containerID)
+	for _, pattern := range c.AllowedHosts {
+		if err := egress.ValidatePattern(pattern); err != nil {
+			return fmt.Errorf("egress: %w", err)
+		}
+	}
+	return nil
+}
 
-	if err := e.startContainer(containerID); err != nil {
-		return "", fmt.Errorf("failed to start container: %v", err)
+// WithEgress lets requests ask for network access through the egress proxy.
+// Without it, requests asking for network access are rejected.
+func WithEgress(config EgressConfig) Option {
+	return func(e *DockerExecutor) {
+		e.egress = &config
 	}
+}
 
-	exitCode, err := e.waitForContainer(containerID, timeout)
+func NewDockerExecutor(languages *language.Registry, opts ...Option) (*DockerExecutor, error) {
+	cli, err := client.NewClientWithOpts(client.FromEnv)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-
-	if err := e.checkContainerStatus(containerID, exitCode); err != nil {
-		return "", err
+	e := &DockerExecutor{client: cli, languages: languages}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.egress != nil {
+		if err := e.egress.validate(); err != nil {
+			cli.Close()
+			return nil, err
+		}
+	}
+	if e.poolConfig != nil {
+		if err := e.poolConfig.validate(languages); err != nil {
+			cli.Close()
+			return nil, err
+		}
+	}
+	if err := e.checkRuntimes(); err != nil {
+		cli.Close()
+		return nil, err
+	}
+	if e.poolConfig != nil {
+		e.pool = newPool(cli, languages, *e.poolConfig, e.sandboxRuntime)
 	}
+	return e, nil
+}
 
-	output, err := e.getContainerOutput(containerID)
+// checkRuntimes verifies that the configured runtimes are registered with
+// the Docker daemon, and defaults the executor's runtime to the daemon's.
+func (e *DockerExecutor) checkRuntimes() error {
+	ctx, cancel := context.WithTimeout(context.Background(), infoTimeout)
+	defer cancel()
+	info, err := e.client.Info(ctx)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("failed to query Docker daemon: %w", err)
 	}
 
-	return output, nil
+	if e.runtime == "" {
+		e.runtime = info.DefaultRuntime
+	} else if _, ok := info.Runtimes[e.runtime]; !ok {
+		return fmt.Errorf("runtime %q is not registered with the Docker daemon", e.runtime)
+	}
+	for _, name := range e.languages.Names() {
+		lang, _ := e.languages.Lookup(name)
+		if _, ok := info.Runtimes[lang.Runtime]; lang.Runtime != "" && !ok {
+			return fmt.Errorf("runtime %q of language %s is not registered with the Docker daemon", lang.Runtime, name)
+		}
+	}
+	return nil
 }
 
-func (e *DockerExecutor) createContainer(code, language string) (string, error) {
-	ctx := context.Background()
-	resp, err := e.client.ContainerCreate(ctx, &container.Config{
-		Image: getImageForLanguage(language),
-		Cmd:   []string{"code." + getFileExtensionForLanguage(language)},
-	}, &container.HostConfig{
-		Resources: container.Resources{
-			Memory:     64 * 1024 * 1024,
-			MemorySwap: 64 * 1024 * 1024,
-			CPUQuota:   50000,
-		},
-		Binds: []string{
-			fmt.Sprintf("%s:/app/code.%s", createCodeFile(code, language), getFileExtensionForLanguage(language)),
-		},
-	}, nil, nil, "")
-	if err != nil {
-		return "", err
+// sandboxRuntime returns the OCI runtime of the language's sandboxes.
+func (e *DockerExecutor) sandboxRuntime(lang *language.Language) string {
+	if lang.Runtime != "" {
+		return lang.Runtime
 	}
-	return resp.ID, nil
+	return e.runtime
 }
 
-func (e *DockerExecutor) startContainer(containerID string) error {
-	ctx := context.Background()
-	return e.client.ContainerStart(ctx, containerID, container.StartOptions{})
+// Close destroys the idle sandboxes of the pool and releases the Docker
+// client. Executions in progress are not affected.
+func (e *DockerExecutor) Close() error {
+	if e.pool != nil {
+		e.pool.close()
+	}
+	return e.client.Close()
 }
 
-func (e *DockerExecutor) waitForContainer(containerID string, timeout time.Duration) (int64, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+func (e *DockerExecutor) Execute(ctx context.Context, req Request) (*Result, error) {
+	start := time.Now()
 
-	statusCh, errCh := e.client.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
-		if err != nil {
-			return 0, fmt.Errorf("failed to wait for container: %v", err)
-		}
-	case result := <-statusCh:
-		return result.StatusCode, nil
-	case <-ctx.Done():
-		return 0, fmt.Errorf("container execution timed out after %s", timeout)
+	lang, ok := e.languages.Lookup(req.Language)
+	if !ok {
+		return nil, fmt.Errorf("unsupported language: %s", req.Language)
 	}
-	return 0, nil
-}
+	limits := lang.Limits.Override(req.Limits)
 
-func (e *DockerExecutor) checkContainerStatus(containerID string, exitCode int64) error {
-	ctx := context.Background()
-	containerInfo, err := e.client.ContainerInspect(ctx, containerID)
+	sb, release, err := e.acquireSandbox(ctx, lang, req.Network)
 	if err != nil {
-		return fmt.Errorf("failed to inspect container: %v", err)
+		return nil, err
 	}
-
-	if containerInfo.State.OOMKilled {
-		return fmt.Errorf("container exceeded memory limit")
-	} else if exitCode != 0 {
-		return fmt.Errorf("container exited with non-zero status code: %d", exitCode)
+	result, err := e.executeIn(ctx, sb, lang, limits, req)
+	teardownStart := time.Now()
+	release()
+	if err != nil {
+		return nil, err
 	}
-	return nil
+
+	result.Timings.CreateMs = sb.createTime.Milliseconds()
+	result.Timings.StartMs = sb.startTime.Milliseconds()
+	result.Timings.TeardownMs = time.Since(teardownStart).Milliseconds()
+	result.WallTimeMs = time.Since(start).Milliseconds()
+	return result, nil
 }
 
-func (e *DockerExecutor) getContainerOutput(containerID string) (string, error) {
-	ctx := context.Background()
-	out, err := e.client.ContainerLogs(ctx, containerID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+// executeIn runs a submission in the sandbox: the files are copied in, then
+// the compiler or syntax checker runs, then the program.
+func (e *DockerExecutor) executeIn(ctx context.Context, sb *sandbox, lang *language.Language, limits language.Limits, req Request) (*Result, error) {
+	result := &Result{
+		SyntaxCheck: SyntaxCheck{Passed: true},
+		Limits:      resultLimits(limits),
+		Runtime:     sb.runtime,
+	}
+	entrypoint, ok, err := prepare(ctx, sb, lang, limits, req, result)
 	if err != nil {
-		return "", fmt.Errorf("failed to retrieve container logs: %v", err)
+		return nil, err
+	}
+	if !ok {
+		return result, nil
 	}
-	defer out.Close()
 
-	var stdout, stderr strings.Builder
-	if _, err := stdcopy.StdCopy(&stdout, &stderr, out); err != nil {
-		return "", fmt.Errorf("failed to read container logs: %v", err)
+	snapshot, err := SnapshotWorkspace(sb.dir)
+	if err != nil {
+		return nil, err
 	}
 
-	if stderr.Len() > 0 {
-		return "", fmt.Errorf("execution error: %s", stderr.String())
+	spec := execSpec{cmd: expandCommand(lang.Run, entrypoint), limits: limits, output: req.Output}
+	if req.Stdin != "" {
+		spec.stdin = strings.NewReader(req.Stdin)
+	}
+	run, err := sb.exec(ctx, spec)
+	if err != nil {
+		return nil, err
 	}
 
-	return strings.TrimSpace(stdout.String()), nil
+	// A program that timed out took its working directory down with the
+	// sandbox
+	if !run.timedOut {
+		copyStart := time.Now()
+		complete, err := sb.copyOut(ctx)
+		if err != nil {
+			return nil, err
+		}
+		artifacts, truncated, err := CollectArtifacts(sb.dir, snapshot)
+		if err != nil {
+			return nil, err
+		}
+		result.Artifacts = artifacts
+		result.ArtifactsTruncated = truncated || !complete
+		result.Timings.CopyMs += time.Since(copyStart).Milliseconds()
+	}
+	recordRun(lang, run, result)
+	return result, nil
 }
 
-func (e *DockerExecutor) removeContainer(containerID string) {
-	ctx := context.Background()
-	e.client.ContainerRemove(ctx, containerID, container.RemoveOptions{})
+// recordRun records the outcome of a program's run in result.
+func recordRun(lang *language.Language, run *execRun, result *Result) {
+	result.Timings.RunMs = run.wallTime.Milliseconds()
+	result.Stdout = run.stdout
+	result.Stderr = run.stderr
+	result.StdoutTruncated = run.stdoutTruncated()
+	result.StderrTruncated = run.stderrTruncated()
+	result.StdoutBytes = run.stdoutBytes
+	result.StderrBytes = run.stderrBytes
+	result.ExitCode = run.exitCode
+	result.CPUUserMs = run.cpuUser.Milliseconds()
+	result.CPUSystemMs = run.cpuSystem.Milliseconds()
+	result.TimedOut = run.timedOut
+	result.OOMKilled = run.oomKilled
+	result.MemoryExceeded = memoryExceeded(lang, run)
+	result.PeakMemoryBytes = run.peakMemory
 }
 
-func (e *DockerExecutor) syntaxCheck(code, language string) error {
-	// Implement syntax check logic based on the language
-	switch language {
-	case "python":
-		// Use a lightweight Python container to check the syntax
-		ctx := context.Background()
-		resp, err := e.client.ContainerCreate(ctx, &container.Config{
-			Image: "python:3.11-alpine",
-			Cmd:   []string{"python", "-c", "import ast; ast.parse('''" + code + "''')"},
-		}, nil, nil, nil, "")
+// prepare copies a submission into the sandbox and runs its compiler or
+// syntax checker, recording their outcome in result. It returns the
+// entrypoint, or false if the submission was rejected, in which case result
+// reports why.
+func prepare(ctx context.Context, sb *sandbox, lang *language.Language, limits language.Limits, req Request, result *Result) (string, bool, error) {
+	copyStart := time.Now()
+	entrypoint, err := MaterializeWorkspace(sb.dir, lang.FileName, req)
+	if err != nil {
+		return "", false, err
+	}
+	if err := sb.copyIn(ctx); err != nil {
+		return "", false, err
+	}
+	result.Timings.CopyMs = time.Since(copyStart).Milliseconds()
+
+	switch {
+	case len(lang.Compile) > 0:
+		compile, err := sb.exec(ctx, execSpec{cmd: expandCommand(lang.Compile, entrypoint), limits: lang.CompileLimits})
 		if err != nil {
-			return err
+			return "", false, fmt.Errorf("failed to run compiler: %w", err)
 		}
-		defer e.client.ContainerRemove(ctx, resp.ID, container.RemoveOptions{})
-
-		if err := e.client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-			return err
+		result.Timings.SyntaxCheckMs = compile.wallTime.Milliseconds()
+		result.Compile = &CompileResult{
+			Succeeded:       compile.exitCode == 0 && !compile.timedOut,
+			Stdout:          compile.stdout,
+			Stderr:          compile.stderr,
+			ExitCode:        compile.exitCode,
+			WallTimeMs:      compile.wallTime.Milliseconds(),
+			CPUUserMs:       compile.cpuUser.Milliseconds(),
+			CPUSystemMs:     compile.cpuSystem.Milliseconds(),
+			TimedOut:        compile.timedOut,
+			OOMKilled:       compile.oomKilled,
+			PeakMemoryBytes: compile.peakMemory,
+			Limits:          resultLimits(lang.CompileLimits),
+			StdoutTruncated: compile.stdoutTruncated(),
+			StderrTruncated: compile.stderrTruncated(),
+			StdoutBytes:     compile.stdoutBytes,
+			StderrBytes:     compile.stderrBytes,
 		}
-
-		statusCh, errCh := e.client.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-		select {
-		case err := <-errCh:
-			if err != nil {
-				return err
-			}
-		case status := <-statusCh:
-			if status.StatusCode != 0 {
-				return fmt.Errorf("syntax check failed")
-			}
+		if !result.Compile.Succeeded {
+			output := compile.stderr + compile.stdout
+			result.SyntaxCheck = SyntaxCheck{Passed: false, Output: output, Diagnostics: ParseDiagnostics(lang, output)}
+			result.ExitCode = -1
+			return "", false, nil
+		}
+		// Snapshot the build outputs as well so that they are not reported
+		// as artifacts
+		copyStart := time.Now()
+		complete, err := sb.copyOut(ctx)
+		if err != nil {
+			return "", false, err
+		}
+		if !complete {
+			return "", false, errors.New("failed to copy build outputs out of sandbox")
 		}
-	case "javascript":
-		ctx := context.Background()
-		codeFile := createCodeFile(code, language) // Ensure this function returns a valid path
-		defer os.Remove(codeFile)                  // Clean up after checking
-
-		// Make sure the container path is specified correctly
-		containerPath := "/app/" + filepath.Base(codeFile) // Use a specific folder instead of root
-
-		resp, err := e.client.ContainerCreate(ctx, &container.Config{
-			Image: "node:19-alpine",
-			Cmd:   []string{"node", "--check", containerPath},
-		}, &container.HostConfig{
-			Binds: []string{fmt.Sprintf("%s:%s", codeFile, containerPath)},
-		}, nil, nil, "")
+		result.Timings.CopyMs += time.Since(copyStart).Milliseconds()
+	case len(lang.SyntaxCheck) > 0:
+		// The checker parses untrusted code, so it gets the same limits as
+		// the run itself, apart from the timeout
+		checkLimits := limits
+		checkLimits.Timeout = language.Duration(syntaxCheckTimeout)
+		check, err := sb.exec(ctx, execSpec{cmd: expandCommand(lang.SyntaxCheck, entrypoint), limits: checkLimits})
 		if err != nil {
-			return err
+			return "", false, fmt.Errorf("failed to run syntax check: %w", err)
 		}
-		defer e.client.ContainerRemove(ctx, resp.ID, container.RemoveOptions{})
+		result.Timings.SyntaxCheckMs = check.wallTime.Milliseconds()
+		// Only the code can make the checker overrun its limits, so that
+		// rejects the submission rather than failing the execution
+		switch {
+		case check.timedOut:
+			result.SyntaxCheck = SyntaxCheck{Passed: false, Output: fmt.Sprintf("syntax check timed out after %s", syntaxCheckTimeout), TimedOut: true}
+		case check.oomKilled:
+			result.SyntaxCheck = SyntaxCheck{Passed: false, Output: fmt.Sprintf("syntax check ran out of memory (%d MB)", checkLimits.MemoryMB), OOMKilled: true}
+		case check.exitCode != 0:
+			result.SyntaxCheck = SyntaxCheck{Passed: false, Output: check.stderr, Diagnostics: ParseDiagnostics(lang, check.stderr)}
+		default:
+			return entrypoint, true, nil
+		}
+		result.ExitCode = -1
+		return "", false, nil
+	}
+	return entrypoint, true, nil
+}
 
-		if err := e.client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
-			return err
+// acquireSandbox returns a sandbox for the language and a function releasing
+// it once the execution is over. Sandboxes without network access are taken
+// from the pool when a healthy one is ready. Sandboxes with network access
+// are always created on demand, since their proxy credentials are specific to
+// the execution.
+func (e *DockerExecutor) acquireSandbox(ctx context.Context, lang *language.Language, network *Network) (*sandbox, func(), error) {
+	if network != nil {
+		sandboxNetwork, revoke, err := e.grantNetwork(network)
+		if err != nil {
+			return nil, nil, err
+		}
+		sb, err := newSandbox(ctx, e.client, lang, e.sandboxRuntime(lang), sandboxNetwork)
+		if err != nil {
+			revoke()
+			return nil, nil, err
 		}
+		return sb, func() { sb.destroy(); revoke() }, nil
+	}
 
-		statusCh, errCh := e.client.ContainerWait(ctx, resp.ID, container.WaitConditionNotRunning)
-		select {
-		case err := <-errCh:
-			if err != nil {
-				return err
-			}
-		case status := <-statusCh:
-			if status.StatusCode != 0 {
-				// Retrieve logs to see the syntax error
-				out, logErr := e.client.ContainerLogs(ctx, resp.ID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
-				if logErr != nil {
-					return fmt.Errorf("syntax check failed with status code %d and unable to retrieve logs: %v", status.StatusCode, logErr)
-				}
-				defer out.Close()
-				var stderr strings.Builder
-				if _, err := stdcopy.StdCopy(nil, &stderr, out); err != nil {
-					return fmt.Errorf("failed to read container logs: %v", err)
-				}
-				return fmt.Errorf("syntax check failed: status code %d, error: %s", status.StatusCode, stderr.String())
+	if e.pool != nil {
+		for sb := e.pool.take(lang.Name); sb != nil; sb = e.pool.take(lang.Name) {
+			if sb.healthy(ctx) {
+				// It was created and started ahead of the request
+				sb.createTime, sb.startTime = 0, 0
+				return sb, sb.destroy, nil
 			}
+			sb.destroy()
 		}
-	default:
-		return fmt.Errorf("unsupported language: %s", language)
 	}
-	return nil
+	sb, err := newSandbox(ctx, e.client, lang, e.sandboxRuntime(lang), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sb, sb.destroy, nil
 }
 
-func createCodeFile(code, language string) string {
-	tempDir, err := os.MkdirTemp("", "code")
-	if err != nil {
-		log.Fatalf("Failed to create temporary directory: %v", err)
+// grantNetwork checks the requested network access against the egress
+// configuration and grants it on the proxy until revoke is called.
+func (e *DockerExecutor) grantNetwork(network *Network) (*sandboxNetwork, func(), error) {
+	if e.egress == nil {
+		return nil, nil, fmt.Errorf("%w: network access is not available", ErrInvalidRequest)
+	}
+	if err := egress.Check(e.egress.AllowedHosts, network.AllowedHosts); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidRequest, err)
 	}
 
-	codeFile := filepath.Join(tempDir, "code."+getFileExtensionForLanguage(language))
-	err = os.WriteFile(codeFile, []byte(code), 0644)
-	if err != nil {
-		log.Fatalf("Failed to write code to file: %v", err)
+	// The URL was validated when the executor was created
+	proxyURL, _ := url.Parse(e.egress.ProxyURL)
+	token, revoke := e.egress.Proxy.Grant(network.AllowedHosts)
+	proxyURL.User = url.User(token)
+	proxy := proxyURL.String()
+	return &sandboxNetwork{
+		name: e.egress.Network,
+		env:  []string{"HTTP_PROXY=" + proxy, "HTTPS_PROXY=" + proxy, "http_proxy=" + proxy, "https_proxy=" + proxy},
+	}, revoke, nil
+}
+
+// killContainer stops a running container right away. It uses its own
+// context because the request context may already be cancelled.
+func killContainer(cli *client.Client, containerID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := cli.ContainerKill(ctx, containerID, "KILL"); err != nil && !errdefs.IsNotFound(err) && !errdefs.IsConflict(err) {
+		log.Printf("Failed to kill container %s: %v", containerID, err)
+	}
+}
+
+// removeContainer force-removes the container, killing it first if it is
+// still running.
+func removeContainer(cli *client.Client, containerID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := cli.ContainerRemove(ctx, containerID, container.RemoveOptions{Force: true, RemoveVolumes: true}); err != nil && !errdefs.IsNotFound(err) {
+		log.Printf("Failed to remove container %s: %v", containerID, err)
+	}
+}
+
+// ParseDiagnostics extracts structured diagnostics from checker or compiler
+// output using the language's diagnostic pattern.
+func ParseDiagnostics(lang *language.Language, output string) []Diagnostic {
+	pattern := lang.Diagnostics()
+	if pattern == nil {
+		return nil
 	}
 
-	return codeFile
+	var diagnostics []Diagnostic
+	for _, match := range pattern.FindAllStringSubmatch(output, -1) {
+		diagnostic := Diagnostic{Message: strings.TrimSpace(match[pattern.SubexpIndex("message")])}
+		if i := pattern.SubexpIndex("file"); i >= 0 {
+			diagnostic.File = strings.TrimPrefix(strings.TrimPrefix(match[i], workDir+"/"), "./")
+		}
+		diagnostic.Line, _ = strconv.Atoi(match[pattern.SubexpIndex("line")])
+		if i := pattern.SubexpIndex("column"); i >= 0 {
+			diagnostic.Column, _ = strconv.Atoi(match[i])
+		} else if i := pattern.SubexpIndex("caret"); i >= 0 {
+			diagnostic.Column = len(match[i]) + 1
+		}
+		diagnostics = append(diagnostics, diagnostic)
+	}
+	return diagnostics
+}
+
+// memoryExceeded reports whether a command failed for lack of memory: it was
+// OOM killed, or the language's runtime reported a failed allocation.
+func memoryExceeded(lang *language.Language, run *execRun) bool {
+	if run.oomKilled {
+		return true
+	}
+	pattern := lang.OutOfMemory()
+	return pattern != nil && run.exitCode != 0 && !run.timedOut && pattern.MatchString(run.stderr)
 }
 
-func getImageForLanguage(language string) string {
-	switch language {
-	case "python":
-		return "python-exec"
-	case "javascript":
-		return "javascript-exec"
-	default:
-		return ""
+func resultLimits(limits language.Limits) Limits {
+	return Limits{
+		TimeoutMs:   time.Duration(limits.Timeout).Milliseconds(),
+		MemoryMB:    limits.MemoryMB,
+		CPUs:        limits.CPUs,
+		Pids:        limits.Pids,
+		OutputBytes: limits.OutputBytes,
 	}
 }
 
-func getFileExtensionForLanguage(language string) string {
-	switch language {
-	case "python":
-		return "py"
-	case "javascript":
-		return "js"
-	default:
-		return ""
+// expandCommand substitutes the entrypoint for the {entrypoint} placeholder
+// in a configured command.
+func expandCommand(cmd []string, entrypoint string) []string {
+	expanded := make([]string, len(cmd))
+	for i, arg := range cmd {
+		expanded[i] = strings.ReplaceAll(arg, "{entrypoint}", entrypoint)
 	}
+	return expanded
 }
@@ -1,5 +1,184 @@
 package executor
 
+import (
+	"context"
+
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// Executor is the contract implemented by every execution backend. The
+// context bounds the whole execution; cancelling it aborts the run.
 type Executor interface {
-	Execute(code, language string) (string, error)
+	Execute(ctx context.Context, req Request) (*Result, error)
+}
+
+// Request describes a single code submission.
+type Request struct {
+	Language string
+	// Code is written to the entrypoint. It may be empty when the entrypoint
+	// is provided through Files or Archive.
+	Code string
+	// Files are additional files keyed by slash-separated path relative to
+	// the working directory.
+	Files map[string]string
+	// Archive is a zip, tar or gzip-compressed tar archive extracted into the
+	// working directory before Files and Code are written.
+	Archive []byte
+	// Entrypoint is the path of the file to run relative to the working
+	// directory. Defaults to the language's file name.
+	Entrypoint string
+	// Stdin is streamed to the program's standard input, which is closed
+	// once it has been written.
+	Stdin string
+	// Limits are the limits of the run. Zero fields select the language's
+	// defaults. They are not checked against the language's maxima; that is
+	// up to the caller.
+	Limits language.Limits
+	// Network, when set, gives the program access to the hosts it allows.
+	// Otherwise the program has no network access.
+	Network *Network
+	// Output, when set, is called with the program's output as it is
+	// written, in addition to reporting it in the result. Output beyond the
+	// output limit is not passed on. Calls are not concurrent and p must not
+	// be retained after the call returns.
+	Output func(stream Stream, p []byte)
+}
+
+// Stream names an output stream of a program.
+type Stream string
+
+const (
+	Stdout Stream = "stdout"
+	Stderr Stream = "stderr"
+)
+
+// Network describes the network access granted to a program.
+type Network struct {
+	// AllowedHosts are the host patterns the program may connect to: host
+	// names, "*.example.com" for every subdomain of example.com, or "*" for
+	// every host.
+	AllowedHosts []string
+}
+
+// Result describes the outcome of a single code execution. Failures of the
+// submitted program (syntax errors, non-zero exits, timeouts, OOM kills) are
+// reported here; errors returned alongside a Result are infrastructure errors.
+type Result struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+	// WallTimeMs is the wall-clock time of the whole execution, broken down
+	// in Timings.
+	WallTimeMs int64 `json:"wall_time_ms"`
+	// CPUUserMs and CPUSystemMs are the CPU time the program used in user
+	// and kernel mode, across all its processes and threads. Unlike the wall
+	// time, they do not depend on the load of the host.
+	CPUUserMs   int64 `json:"cpu_user_ms"`
+	CPUSystemMs int64 `json:"cpu_system_ms"`
+	TimedOut    bool  `json:"timed_out"`
+	// OOMKilled is set when the program was killed for exceeding its memory
+	// limit.
+	OOMKilled bool `json:"oom_killed"`
+	// MemoryExceeded is set when the program failed for lack of memory:
+	// either it was OOM killed, or its runtime reported a failed allocation
+	// such as Python's MemoryError.
+	MemoryExceeded bool `json:"memory_exceeded"`
+	// PeakMemoryBytes is the highest memory usage of the sandbox sampled
+	// while the program ran.
+	PeakMemoryBytes int64       `json:"peak_memory_bytes"`
+	SyntaxCheck     SyntaxCheck `json:"syntax_check"`
+	// Limits are the limits the run phase was given.
+	Limits Limits `json:"limits"`
+	// StdoutTruncated and StderrTruncated report output discarded over the
+	// output_bytes limit. StdoutBytes and StderrBytes count all the output
+	// the program wrote, discarded or not.
+	StdoutTruncated bool  `json:"stdout_truncated"`
+	StderrTruncated bool  `json:"stderr_truncated"`
+	StdoutBytes     int64 `json:"stdout_bytes"`
+	StderrBytes     int64 `json:"stderr_bytes"`
+	// Timings are the durations of the execution's phases.
+	Timings Timings `json:"timings"`
+	// Runtime is the OCI runtime the sandbox ran under.
+	Runtime string `json:"runtime,omitempty"`
+	// Compile is set for compiled languages. When compilation fails the
+	// program is not run and SyntaxCheck carries the compiler diagnostics.
+	Compile *CompileResult `json:"compile,omitempty"`
+	// Artifacts are the files the program created or modified in its
+	// working directory.
+	Artifacts []Artifact `json:"artifacts,omitempty"`
+	// ArtifactsTruncated is set when some of the program's files are
+	// missing from Artifacts, because there were too many of them or they
+	// could not be copied out of the sandbox.
+	ArtifactsTruncated bool `json:"artifacts_truncated,omitempty"`
+}
+
+// CompileResult holds the outcome of the compile phase.
+type CompileResult struct {
+	Succeeded       bool   `json:"succeeded"`
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	ExitCode        int    `json:"exit_code"`
+	WallTimeMs      int64  `json:"wall_time_ms"`
+	CPUUserMs       int64  `json:"cpu_user_ms"`
+	CPUSystemMs     int64  `json:"cpu_system_ms"`
+	TimedOut        bool   `json:"timed_out"`
+	OOMKilled       bool   `json:"oom_killed"`
+	Limits          Limits `json:"limits"`
+	PeakMemoryBytes int64  `json:"peak_memory_bytes"`
+	// Output truncation and byte counts, as in Result.
+	StdoutTruncated bool  `json:"stdout_truncated"`
+	StderrTruncated bool  `json:"stderr_truncated"`
+	StdoutBytes     int64 `json:"stdout_bytes"`
+	StderrBytes     int64 `json:"stderr_bytes"`
+}
+
+// Timings break the wall time of an execution down into phases, in
+// milliseconds. Creating and starting take no time for sandboxes taken from
+// the warm pool.
+type Timings struct {
+	// CreateMs and StartMs are the time taken to create and start the
+	// sandbox's container.
+	CreateMs int64 `json:"create_ms"`
+	StartMs  int64 `json:"start_ms"`
+	// CopyMs is the time taken to copy the submission into the sandbox and
+	// the artifacts out of it.
+	CopyMs int64 `json:"copy_ms"`
+	// SyntaxCheckMs is the time taken by the syntax check, or by the compile
+	// phase for compiled languages.
+	SyntaxCheckMs int64 `json:"syntax_check_ms"`
+	RunMs         int64 `json:"run_ms"`
+	// TeardownMs is the time taken to remove the sandbox.
+	TeardownMs int64 `json:"teardown_ms"`
+}
+
+// Limits are the resource limits a phase ran under. The same fields are
+// used by clients to request limits.
+type Limits struct {
+	TimeoutMs   int64   `json:"timeout_ms"`
+	MemoryMB    int64   `json:"memory_mb"`
+	CPUs        float64 `json:"cpus"`
+	Pids        int64   `json:"pids"`
+	OutputBytes int64   `json:"output_bytes"`
+}
+
+// SyntaxCheck holds the outcome of the pre-execution syntax check, or of the
+// compile phase for compiled languages.
+type SyntaxCheck struct {
+	Passed bool   `json:"passed"`
+	Output string `json:"output,omitempty"`
+	// Diagnostics are the errors parsed from Output.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	// TimedOut and OOMKilled report a syntax checker stopped by its time or
+	// memory limit, which rejects the submission as well.
+	TimedOut  bool `json:"timed_out,omitempty"`
+	OOMKilled bool `json:"oom_killed,omitempty"`
+}
+
+// Diagnostic is a single error reported by a syntax checker or compiler.
+// Line and Column are 1-based; Column is 0 when the tool does not report it.
+type Diagnostic struct {
+	File    string `json:"file,omitempty"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
 }
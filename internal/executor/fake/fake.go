@@ -0,0 +1,433 @@
+// Package fake provides a scriptable in-memory executor.Executor, letting
+// the HTTP layer be exercised without a Docker daemon.
+package fake
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/isavita/codeexec/internal/executor"
+)
+
+var (
+	_ executor.Executor        = (*Executor)(nil)
+	_ executor.SessionExecutor = (*Executor)(nil)
+	_ executor.KernelExecutor  = (*Executor)(nil)
+	_ executor.BatchExecutor   = (*Executor)(nil)
+)
+
+// Response scripts the outcome of an Execute call.
+type Response struct {
+	// Result is returned as-is (copied) when Err is nil.
+	Result executor.Result
+	// Err simulates an infrastructure failure.
+	Err error
+	// Delay simulates the run time. A delay longer than the request timeout
+	// produces a timed-out result once the timeout elapses. The result's
+	// output is passed to the request's Output once the delay is over.
+	Delay time.Duration
+	// Value is the value of the last expression of a cell evaluated by a
+	// kernel.
+	Value string
+}
+
+// Executor is an in-memory executor whose responses are programmed per code
+// snippet. It records every request it receives.
+type Executor struct {
+	mu        sync.Mutex
+	responses map[string]Response
+	fallback  Response
+	requests  []executor.Request
+	sessions  []*Session
+	kernels   []*Kernel
+	batches   int
+}
+
+// New returns a fake executor that answers unscripted submissions with an
+// empty, successful result.
+func New() *Executor {
+	return &Executor{
+		responses: make(map[string]Response),
+		fallback:  Output(""),
+	}
+}
+
+// On scripts the response for submissions whose code equals code.
+func (e *Executor) On(code string, resp Response) *Executor {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.responses[code] = resp
+	return e
+}
+
+// Default scripts the response for submissions without a specific script.
+func (e *Executor) Default(resp Response) *Executor {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.fallback = resp
+	return e
+}
+
+// Requests returns the requests received so far, in order.
+func (e *Executor) Requests() []executor.Request {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]executor.Request(nil), e.requests...)
+}
+
+// Sessions returns the sessions started so far, in order.
+func (e *Executor) Sessions() []*Session {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Session(nil), e.sessions...)
+}
+
+// Kernels returns the kernels started so far, in order.
+func (e *Executor) Kernels() []*Kernel {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*Kernel(nil), e.kernels...)
+}
+
+func (e *Executor) Execute(ctx context.Context, req executor.Request) (*executor.Result, error) {
+	e.mu.Lock()
+	e.requests = append(e.requests, req)
+	resp, ok := e.responses[req.Code]
+	if !ok {
+		resp = e.fallback
+	}
+	e.mu.Unlock()
+
+	result := resp.Result
+	delay := resp.Delay
+	timeout := time.Duration(req.Limits.Timeout)
+	timedOut := timeout > 0 && delay > timeout
+	if timedOut {
+		delay = timeout
+	}
+
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+	if timedOut {
+		result.TimedOut = true
+		result.ExitCode = -1
+	}
+	if req.Output != nil {
+		if result.Stdout != "" {
+			req.Output(executor.Stdout, []byte(result.Stdout))
+		}
+		if result.Stderr != "" {
+			req.Output(executor.Stderr, []byte(result.Stderr))
+		}
+	}
+	result.WallTimeMs = delay.Milliseconds()
+	result.Timings.RunMs = delay.Milliseconds()
+	return &result, nil
+}
+
+// ExecuteBatch executes the request once per run, as Execute does.
+func (e *Executor) ExecuteBatch(ctx context.Context, req executor.Request, runs []executor.Run) (*executor.BatchResult, error) {
+	e.mu.Lock()
+	e.batches++
+	e.mu.Unlock()
+	return executor.ExecuteEach(ctx, e, req, runs)
+}
+
+// Batches returns the number of batches executed so far.
+func (e *Executor) Batches() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.batches
+}
+
+// StartSession starts an echoing terminal: the scripted stdout is written
+// first, then every input is written back, until the input contains Ctrl-D
+// and the session exits with the scripted exit code. Submissions scripted to
+// fail their syntax check return their result instead. Errors and delays
+// are scripted as for Execute, without timing out.
+func (e *Executor) StartSession(ctx context.Context, req executor.Request) (executor.Session, *executor.Result, error) {
+	e.mu.Lock()
+	e.requests = append(e.requests, req)
+	resp, ok := e.responses[req.Code]
+	if !ok {
+		resp = e.fallback
+	}
+	e.mu.Unlock()
+
+	if resp.Delay > 0 {
+		timer := time.NewTimer(resp.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+	if resp.Err != nil {
+		return nil, nil, resp.Err
+	}
+	if !resp.Result.SyntaxCheck.Passed {
+		result := resp.Result
+		return nil, &result, nil
+	}
+
+	r, w := io.Pipe()
+	s := &Session{output: r, input: w, exitCode: resp.Result.ExitCode, done: make(chan struct{})}
+	s.stopKill = context.AfterFunc(ctx, func() { s.exit(-1) })
+	if resp.Result.Stdout != "" {
+		go io.WriteString(w, resp.Result.Stdout)
+	}
+
+	e.mu.Lock()
+	e.sessions = append(e.sessions, s)
+	e.mu.Unlock()
+	return s, nil, nil
+}
+
+// Session is the echoing terminal started by StartSession.
+type Session struct {
+	output   *io.PipeReader
+	input    *io.PipeWriter
+	stopKill func() bool
+
+	mu       sync.Mutex
+	exitCode int
+	exited   bool
+	sizes    [][2]uint
+	done     chan struct{}
+}
+
+func (s *Session) Read(p []byte) (int, error) {
+	return s.output.Read(p)
+}
+
+func (s *Session) Write(p []byte) (int, error) {
+	echo, _, eof := bytes.Cut(p, []byte{'\x04'})
+	if _, err := s.input.Write(echo); err != nil {
+		return 0, err
+	}
+	if eof {
+		s.mu.Lock()
+		exitCode := s.exitCode
+		s.mu.Unlock()
+		s.exit(exitCode)
+	}
+	return len(p), nil
+}
+
+func (s *Session) Resize(rows, cols uint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sizes = append(s.sizes, [2]uint{rows, cols})
+	return nil
+}
+
+// Sizes returns the terminal sizes requested so far, as rows and columns.
+func (s *Session) Sizes() [][2]uint {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][2]uint(nil), s.sizes...)
+}
+
+func (s *Session) Wait() (int, error) {
+	<-s.done
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exitCode, nil
+}
+
+func (s *Session) Close() error {
+	s.stopKill()
+	s.exit(-1)
+	return nil
+}
+
+// exit ends the session's output with the exit code, unless it already
+// exited.
+func (s *Session) exit(exitCode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.exited {
+		return
+	}
+	s.exited = true
+	s.exitCode = exitCode
+	s.input.Close()
+	close(s.done)
+}
+
+// StartKernel starts a kernel answering each cell with the response scripted
+// for its code.
+func (e *Executor) StartKernel(ctx context.Context, req executor.Request) (executor.Kernel, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.requests = append(e.requests, req)
+	k := &Kernel{exec: e, timeout: time.Duration(req.Limits.Timeout), stop: make(chan struct{})}
+	e.kernels = append(e.kernels, k)
+	return k, nil
+}
+
+// Kernel is the kernel started by StartKernel. Cells scripted to time out
+// or to be OOM killed take it down, as they would a real kernel.
+type Kernel struct {
+	exec     *Executor
+	timeout  time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	mu     sync.Mutex
+	cells  []string
+	exited bool
+}
+
+func (k *Kernel) Execute(ctx context.Context, code string) (*executor.CellResult, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.exited || k.Closed() {
+		return nil, executor.ErrKernelExited
+	}
+	k.cells = append(k.cells, code)
+
+	k.exec.mu.Lock()
+	resp, ok := k.exec.responses[code]
+	if !ok {
+		resp = k.exec.fallback
+	}
+	k.exec.mu.Unlock()
+
+	delay := resp.Delay
+	timedOut := k.timeout > 0 && delay > k.timeout
+	if timedOut {
+		delay = k.timeout
+	}
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			k.exited = true
+			return nil, fmt.Errorf("execution cancelled: %w: %w", ctx.Err(), executor.ErrKernelExited)
+		case <-k.stop:
+			k.exited = true
+			return nil, executor.ErrKernelExited
+		}
+	}
+	if resp.Err != nil {
+		return nil, resp.Err
+	}
+
+	result := &executor.CellResult{
+		Stdout:      resp.Result.Stdout,
+		Stderr:      resp.Result.Stderr,
+		Value:       resp.Value,
+		StdoutBytes: int64(len(resp.Result.Stdout)),
+		StderrBytes: int64(len(resp.Result.Stderr)),
+		WallTimeMs:  delay.Milliseconds(),
+		TimedOut:    timedOut,
+		OOMKilled:   resp.Result.OOMKilled,
+	}
+	if timedOut || resp.Result.OOMKilled {
+		k.exited = true
+		result.KernelExited = true
+	}
+	return result, nil
+}
+
+// Cells returns the code of the cells evaluated so far, in order.
+func (k *Kernel) Cells() []string {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return append([]string(nil), k.cells...)
+}
+
+// Closed reports whether the kernel was closed.
+func (k *Kernel) Closed() bool {
+	select {
+	case <-k.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close ends the cell being evaluated, if any.
+func (k *Kernel) Close() error {
+	k.stopOnce.Do(func() { close(k.stop) })
+	return nil
+}
+
+// Output scripts a successful run printing stdout.
+func Output(stdout string) Response {
+	return Response{Result: executor.Result{
+		Stdout:      stdout,
+		SyntaxCheck: executor.SyntaxCheck{Passed: true},
+	}}
+}
+
+// Failure scripts a run that exits with exitCode after writing stderr.
+func Failure(exitCode int, stderr string) Response {
+	return Response{Result: executor.Result{
+		Stderr:      stderr,
+		ExitCode:    exitCode,
+		SyntaxCheck: executor.SyntaxCheck{Passed: true},
+	}}
+}
+
+// SyntaxError scripts a submission rejected by the syntax check.
+func SyntaxError(output string) Response {
+	return Response{Result: executor.Result{
+		ExitCode:    -1,
+		SyntaxCheck: executor.SyntaxCheck{Passed: false, Output: output},
+	}}
+}
+
+// CompileError scripts a submission rejected by the compiler.
+func CompileError(diagnostics string) Response {
+	return Response{Result: executor.Result{
+		ExitCode:    -1,
+		SyntaxCheck: executor.SyntaxCheck{Passed: false, Output: diagnostics},
+		Compile: &executor.CompileResult{
+			Succeeded: false,
+			Stderr:    diagnostics,
+			ExitCode:  1,
+		},
+	}}
+}
+
+// OOM scripts a run killed for exceeding its memory limit.
+func OOM() Response {
+	return Response{Result: executor.Result{
+		ExitCode:       137,
+		OOMKilled:      true,
+		MemoryExceeded: true,
+		SyntaxCheck:    executor.SyntaxCheck{Passed: true},
+	}}
+}
+
+// Timeout scripts a run that outlives any request timeout.
+func Timeout() Response {
+	resp := Output("")
+	resp.Delay = time.Hour
+	return resp
+}
+
+// Error scripts an infrastructure failure.
+func Error(err error) Response {
+	return Response{Err: err}
+}
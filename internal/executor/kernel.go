@@ -0,0 +1,328 @@
+package executor
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/pkg/stdcopy"
+
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// ErrKernelExited is returned when evaluating code in a kernel that has
+// exited.
+var ErrKernelExited = errors.New("kernel has exited")
+
+// errReplyTooLong is returned by readReply for a line over maxReplyBytes.
+var errReplyTooLong = errors.New("kernel reply too long")
+
+// maxReplyBytes bounds a kernel's reply line. Its output, value and error
+// are each cut to the output limit, which JSON escaping may grow up to
+// sixfold, and the rest of the reply is small.
+func maxReplyBytes(outputBytes int64) int64 {
+	return 4*6*outputBytes + 64<<10
+}
+
+// Kernel evaluates code cell by cell in a long-lived process of a language,
+// so that the variables, functions and imports of a cell are available to
+// the following ones.
+type Kernel interface {
+	// Execute evaluates a cell under the kernel's limits. A cell that times
+	// out or runs out of memory takes the kernel down with it, which is
+	// reported by the result's KernelExited flag. Cancelling ctx kills the
+	// kernel as well, and the error returned then wraps both ctx's error and
+	// ErrKernelExited.
+	Execute(ctx context.Context, code string) (*CellResult, error)
+	// Close kills the kernel and releases its sandbox. A cell being
+	// evaluated ends with ErrKernelExited.
+	Close() error
+}
+
+// KernelExecutor is implemented by executors that can run kernels.
+type KernelExecutor interface {
+	// StartKernel starts a kernel of the request's language in a sandbox
+	// seeded with the request's files and archive, which it keeps until it
+	// is closed. The request's limits apply to each cell; its code, stdin
+	// and entrypoint are ignored. ctx only bounds starting the kernel.
+	StartKernel(ctx context.Context, req Request) (Kernel, error)
+}
+
+// CellResult describes the outcome of evaluating a cell. Errors raised by
+// the cell are reported here; errors returned alongside a CellResult are
+// infrastructure errors.
+type CellResult struct {
+	Stdout string `json:"stdout"`
+	Stderr string `json:"stderr"`
+	// Value is the representation of the value of the cell's last
+	// expression, such as Python's repr, unless it has none.
+	Value string `json:"value,omitempty"`
+	// Error is the error the cell raised, such as "NameError: name 'x' is
+	// not defined". Its traceback is written to Stderr.
+	Error      string `json:"error,omitempty"`
+	WallTimeMs int64  `json:"wall_time_ms"`
+	TimedOut   bool   `json:"timed_out"`
+	OOMKilled  bool   `json:"oom_killed"`
+	// KernelExited is set when the kernel is gone after the cell, along with
+	// the state of the session.
+	KernelExited bool `json:"kernel_exited"`
+	// StdoutTruncated and StderrTruncated report output discarded over the
+	// output_bytes limit. StdoutBytes and StderrBytes count all the output
+	// the cell wrote, discarded or not.
+	StdoutTruncated bool  `json:"stdout_truncated"`
+	StderrTruncated bool  `json:"stderr_truncated"`
+	StdoutBytes     int64 `json:"stdout_bytes"`
+	StderrBytes     int64 `json:"stderr_bytes"`
+}
+
+var _ KernelExecutor = (*DockerExecutor)(nil)
+
+func (e *DockerExecutor) StartKernel(ctx context.Context, req Request) (Kernel, error) {
+	lang, ok := e.languages.Lookup(req.Language)
+	if !ok {
+		return nil, fmt.Errorf("unsupported language: %s", req.Language)
+	}
+	cmd := lang.KernelCommand()
+	if cmd == nil {
+		return nil, fmt.Errorf("%w: %s does not support sessions", ErrInvalidRequest, lang.Name)
+	}
+	limits := lang.Limits.Override(req.Limits)
+
+	sb, release, err := e.acquireSandbox(ctx, lang, req.Network)
+	if err != nil {
+		return nil, err
+	}
+	kernel, err := startKernel(ctx, sb, cmd, limits, req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	kernel.release = release
+	return kernel, nil
+}
+
+// dockerKernel is a kernel process in a sandbox, to which cells are written
+// as JSON lines on its stdin and which replies with a JSON line on its
+// stdout per cell.
+type dockerKernel struct {
+	sb      *sandbox
+	limits  language.Limits
+	attach  types.HijackedResponse
+	replies *bufio.Reader
+	// pipe is read through replies. Closing it stops the copy of the
+	// kernel's stdout once replies are no longer read.
+	pipe    *io.PipeReader
+	release func()
+
+	// mu serializes cells.
+	mu     sync.Mutex
+	exited bool
+	// closed is set by Close, which does not wait for the cell being
+	// evaluated, so that the cell ends with ErrKernelExited rather than with
+	// the errors of a removed container.
+	closed    atomic.Bool
+	closeOnce sync.Once
+}
+
+// kernelRequest is the line a kernel reads per cell. Nonce is generated per
+// cell and echoed in the reply, so that lines the cell itself writes to the
+// kernel's stdout are not taken for its reply.
+type kernelRequest struct {
+	Code        string `json:"code"`
+	OutputBytes int64  `json:"output_bytes"`
+	Nonce       string `json:"nonce"`
+}
+
+// kernelReply is the line a kernel writes per cell. Output is cut to the
+// output limit by the kernel itself.
+type kernelReply struct {
+	Nonce       string `json:"nonce"`
+	Stdout      string `json:"stdout"`
+	Stderr      string `json:"stderr"`
+	StdoutBytes int64  `json:"stdout_bytes"`
+	StderrBytes int64  `json:"stderr_bytes"`
+	Value       string `json:"value"`
+	Error       string `json:"error"`
+}
+
+// startKernel seeds the sandbox's working directory with the request's files
+// and runs the kernel command in it.
+func startKernel(ctx context.Context, sb *sandbox, cmd []string, limits language.Limits, req Request) (*dockerKernel, error) {
+	if len(req.Files) > 0 || len(req.Archive) > 0 {
+		w := &workspaceWriter{dir: sb.dir}
+		if err := w.writeFiles(req); err != nil {
+			return nil, err
+		}
+		if err := sb.copyIn(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if err := sb.setLimits(ctx, limits); err != nil {
+		return nil, err
+	}
+
+	created, err := sb.cli.ContainerExecCreate(ctx, sb.id, types.ExecConfig{
+		Cmd:          cmd,
+		WorkingDir:   workDir,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec: %w", err)
+	}
+	attach, err := sb.cli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start exec: %w", err)
+	}
+
+	// The kernel's own stderr only carries its crashes, which end its
+	// replies anyway
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := stdcopy.StdCopy(pw, io.Discard, attach.Reader)
+		pw.CloseWithError(err)
+	}()
+	return &dockerKernel{sb: sb, limits: limits, attach: attach, replies: bufio.NewReader(pr), pipe: pr}, nil
+}
+
+func (k *dockerKernel) Execute(ctx context.Context, code string) (*CellResult, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.exited || k.closed.Load() {
+		return nil, ErrKernelExited
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("executor: failed to generate nonce: %v", err))
+	}
+	nonce := hex.EncodeToString(b)
+	line, err := json.Marshal(kernelRequest{Code: code, OutputBytes: k.limits.OutputBytes, Nonce: nonce})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode cell: %w", err)
+	}
+	type outcome struct {
+		reply *kernelReply
+		err   error
+	}
+	done := make(chan outcome, 1)
+	started := time.Now()
+	go func() {
+		if _, err := k.attach.Conn.Write(append(line, '\n')); err != nil {
+			done <- outcome{err: err}
+			return
+		}
+		reply, err := k.readReply(nonce)
+		done <- outcome{reply, err}
+	}()
+
+	timer := time.NewTimer(time.Duration(k.limits.Timeout))
+	defer timer.Stop()
+	result := &CellResult{}
+	var out outcome
+	select {
+	case out = <-done:
+	case <-timer.C:
+		k.sb.kill()
+		<-done
+		k.exited = true
+		result.WallTimeMs = time.Since(started).Milliseconds()
+		result.TimedOut = true
+		result.KernelExited = true
+		return result, nil
+	case <-ctx.Done():
+		k.sb.kill()
+		<-done
+		k.exited = true
+		return nil, fmt.Errorf("execution cancelled: %w: %w", ctx.Err(), ErrKernelExited)
+	}
+	result.WallTimeMs = time.Since(started).Milliseconds()
+
+	if out.err != nil && k.closed.Load() {
+		k.exited = true
+		return nil, ErrKernelExited
+	}
+	if errors.Is(out.err, errReplyTooLong) {
+		// The kernel's stdout is flooded, which would otherwise be read
+		// into memory until the cell times out
+		k.sb.kill()
+		k.pipe.Close()
+		k.exited = true
+		result.KernelExited = true
+		result.Error = out.err.Error()
+		return result, nil
+	}
+	if out.err != nil {
+		// The kernel died in the middle of the cell
+		k.exited = true
+		oomKilled, err := k.sb.checkOOMKilled(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result.OOMKilled = oomKilled
+		result.KernelExited = true
+		result.Error = "kernel exited"
+		return result, nil
+	}
+	reply := out.reply
+	result.Stdout = reply.Stdout
+	result.Stderr = reply.Stderr
+	result.Value = reply.Value
+	result.Error = reply.Error
+	result.StdoutBytes = reply.StdoutBytes
+	result.StderrBytes = reply.StderrBytes
+	result.StdoutTruncated = reply.StdoutBytes > k.limits.OutputBytes
+	result.StderrTruncated = reply.StderrBytes > k.limits.OutputBytes
+	return result, nil
+}
+
+// readReply reads the kernel's reply to the cell with the given nonce.
+// Lines that are not replies carrying it, written by the cell, are skipped.
+// Lines over maxReplyBytes end with errReplyTooLong.
+func (k *dockerKernel) readReply(nonce string) (*kernelReply, error) {
+	limit := maxReplyBytes(k.limits.OutputBytes)
+	for {
+		var line []byte
+		for {
+			chunk, err := k.replies.ReadSlice('\n')
+			if int64(len(line)+len(chunk)) > limit {
+				return nil, errReplyTooLong
+			}
+			line = append(line, chunk...)
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+		var reply kernelReply
+		if json.Unmarshal(line, &reply) == nil && reply.Nonce == nonce {
+			return &reply, nil
+		}
+	}
+}
+
+func (k *dockerKernel) Close() error {
+	k.closeOnce.Do(func() {
+		k.closed.Store(true)
+		k.attach.Close()
+		k.pipe.Close()
+		k.release()
+	})
+	return nil
+}
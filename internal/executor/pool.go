@@ -0,0 +1,230 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/client"
+
+	"github.com/isavita/codeexec/internal/language"
+)
+
+const (
+	// sandboxLabel marks the containers created by the executor with the
+	// name of their language.
+	sandboxLabel = "codeexec.language"
+	// sandboxCreateTimeout bounds creating and starting a pooled sandbox.
+	sandboxCreateTimeout = time.Minute
+	// defaultHealthCheckInterval is used when PoolConfig leaves it unset.
+	defaultHealthCheckInterval = 30 * time.Second
+)
+
+// PoolConfig configures the warm pool of idle sandboxes kept ready for each
+// language, so that requests do not wait for a container to be created and
+// started.
+type PoolConfig struct {
+	// Sizes is the number of idle sandboxes kept per language name.
+	// Languages without an entry are not pooled.
+	Sizes map[string]int
+	// HealthCheckInterval is how often idle sandboxes are checked and the
+	// pool is refilled. Defaults to 30 seconds.
+	HealthCheckInterval time.Duration
+}
+
+// pool keeps idle sandboxes ready per language. A sandbox taken from the
+// pool is never returned to it; the pool is refilled in the background
+// instead.
+type pool struct {
+	cli       *client.Client
+	languages *language.Registry
+	config    PoolConfig
+	// runtime returns the OCI runtime of a language's sandboxes.
+	runtime func(*language.Language) string
+
+	mu      sync.Mutex
+	idle    map[string][]*sandbox
+	pending map[string]int
+	closed  bool
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (c PoolConfig) validate(languages *language.Registry) error {
+	for name, size := range c.Sizes {
+		if _, ok := languages.Lookup(name); !ok {
+			return fmt.Errorf("pool: unsupported language: %s", name)
+		}
+		if size < 0 {
+			return fmt.Errorf("pool: size for %s must not be negative", name)
+		}
+	}
+	return nil
+}
+
+// newPool starts filling a pool with a validated config.
+func newPool(cli *client.Client, languages *language.Registry, config PoolConfig, runtime func(*language.Language) string) *pool {
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = defaultHealthCheckInterval
+	}
+
+	p := &pool{
+		cli:       cli,
+		languages: languages,
+		config:    config,
+		runtime:   runtime,
+		idle:      make(map[string][]*sandbox),
+		pending:   make(map[string]int),
+		stop:      make(chan struct{}),
+	}
+	for name := range config.Sizes {
+		p.fill(name)
+	}
+	p.wg.Add(1)
+	go p.maintain()
+	return p
+}
+
+// take removes an idle sandbox for the language from the pool and starts
+// replacing it. It returns nil when none is ready.
+func (p *pool) take(name string) *sandbox {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idle := p.idle[name]
+	if len(idle) == 0 {
+		return nil
+	}
+	sb := idle[0]
+	p.idle[name] = idle[1:]
+	p.fillLocked(name)
+	return sb
+}
+
+// fill starts creating sandboxes until the language's pool is full.
+func (p *pool) fill(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fillLocked(name)
+}
+
+func (p *pool) fillLocked(name string) {
+	lang, ok := p.languages.Lookup(name)
+	if !ok || p.closed {
+		return
+	}
+	for len(p.idle[name])+p.pending[name] < p.config.Sizes[name] {
+		p.pending[name]++
+		p.wg.Add(1)
+		go p.create(lang)
+	}
+}
+
+// create adds a new sandbox to the pool. Failures are logged and retried on
+// the next health check.
+func (p *pool) create(lang *language.Language) {
+	defer p.wg.Done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sandboxCreateTimeout)
+	defer cancel()
+	sb, err := newSandbox(ctx, p.cli, lang, p.runtime(lang), nil)
+
+	p.mu.Lock()
+	p.pending[lang.Name]--
+	closed := p.closed
+	if err == nil && !closed {
+		p.idle[lang.Name] = append(p.idle[lang.Name], sb)
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Failed to create pooled %s sandbox: %v", lang.Name, err)
+	} else if closed {
+		sb.destroy()
+	}
+}
+
+// maintain periodically replaces idle sandboxes whose container has stopped
+// and refills the pool.
+func (p *pool) maintain() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.config.HealthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.checkHealth()
+		}
+	}
+}
+
+func (p *pool) checkHealth() {
+	p.mu.Lock()
+	var idle []*sandbox
+	for _, sandboxes := range p.idle {
+		idle = append(idle, sandboxes...)
+	}
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	unhealthy := make(map[*sandbox]bool)
+	for _, sb := range idle {
+		if !sb.healthy(ctx) {
+			unhealthy[sb] = true
+		}
+	}
+
+	// Sandboxes taken while the check ran are no longer the pool's concern
+	var discarded []*sandbox
+	p.mu.Lock()
+	for name, sandboxes := range p.idle {
+		var kept []*sandbox
+		for _, sb := range sandboxes {
+			if unhealthy[sb] {
+				discarded = append(discarded, sb)
+			} else {
+				kept = append(kept, sb)
+			}
+		}
+		p.idle[name] = kept
+	}
+	for name := range p.config.Sizes {
+		p.fillLocked(name)
+	}
+	p.mu.Unlock()
+
+	for _, sb := range discarded {
+		log.Printf("Discarding unhealthy %s sandbox %s", sb.lang.Name, sb.id)
+		sb.destroy()
+	}
+}
+
+// close stops refilling the pool and destroys the idle sandboxes.
+func (p *pool) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.stop)
+	p.mu.Unlock()
+
+	p.wg.Wait()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, sandboxes := range p.idle {
+		for _, sb := range sandboxes {
+			sb.destroy()
+		}
+	}
+	p.idle = nil
+}
@@ -0,0 +1,434 @@
+package executor
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-units"
+
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// idleCommand keeps a sandbox running until it is used. It is available in
+// every busybox and coreutils based image.
+var idleCommand = []string{"tail", "-f", "/dev/null"}
+
+// oomPeakRatio is the share of its memory limit a command killed by SIGKILL
+// must have been sampled at to count as OOM killed.
+const oomPeakRatio = 0.9
+
+// seccompProfile is Docker's default seccomp profile with ptrace,
+// process_vm_readv and process_vm_writev, which let a process inspect others,
+// removed from its unconditional allow list: they are only allowed along
+// with CAP_SYS_PTRACE, which sandboxes drop unless a language adds it back.
+// name_to_handle_at, which opens files by handle, is not allowed at all.
+//
+//go:embed seccomp.json
+var seccompProfile string
+
+// sandbox is an idle container of a language's image with a tmpfs as its
+// working directory. Files are copied in and out through exec, so that the
+// program cannot reach the host through its working directory. Commands run
+// in it through exec, and it is destroyed after serving a single request.
+type sandbox struct {
+	cli *client.Client
+	id  string
+	// dir is the host directory whose files are copied in and out of
+	// workDir.
+	dir     string
+	lang    *language.Language
+	runtime string
+	limits  language.Limits
+	// createTime and startTime are the time taken to create and start the
+	// container.
+	createTime time.Duration
+	startTime  time.Duration
+	// oomKilled is the container's OOM flag after the previous command, so
+	// that an OOM kill is attributed to the command that caused it.
+	oomKilled bool
+}
+
+// execSpec describes one command to run in a sandbox.
+type execSpec struct {
+	cmd    []string
+	limits language.Limits
+	// user overrides the language's user, e.g. to copy files in as root.
+	user string
+	// stdin, when set, is streamed to the command's standard input.
+	stdin io.Reader
+	// stdout, when set, receives the command's standard output in full
+	// instead of execRun.stdout.
+	stdout io.Writer
+	// output, when set, receives the command's output as it is written, up
+	// to the output limit.
+	output func(stream Stream, p []byte)
+	// noStats skips sampling the command's resource usage, for the
+	// executor's own commands.
+	noStats bool
+}
+
+// execRun is the outcome of running one command in a sandbox.
+type execRun struct {
+	exitCode int
+	timedOut bool
+	// oomKilled is set when the kernel's OOM killer killed a process of the
+	// command, or when it was killed by SIGKILL with its memory usage close
+	// to the limit.
+	oomKilled bool
+	// peakMemory is the highest memory usage sampled in bytes.
+	peakMemory int64
+	// cpuUser and cpuSystem are the CPU time the command used in user and
+	// kernel mode.
+	cpuUser   time.Duration
+	cpuSystem time.Duration
+	stdout    string
+	stderr    string
+	// stdoutBytes and stderrBytes count the output written, including what
+	// was discarded over the output limit.
+	stdoutBytes int64
+	stderrBytes int64
+	wallTime    time.Duration
+}
+
+// stdoutTruncated reports whether stdout lost bytes to the output limit.
+func (r *execRun) stdoutTruncated() bool {
+	return r.stdoutBytes > int64(len(r.stdout))
+}
+
+// stderrTruncated reports whether stderr lost bytes to the output limit.
+func (r *execRun) stderrTruncated() bool {
+	return r.stderrBytes > int64(len(r.stderr))
+}
+
+// sandboxNetwork attaches a sandbox to a Docker network.
+type sandboxNetwork struct {
+	// name is the Docker network to join.
+	name string
+	// env is added to the sandbox's environment, e.g. to point clients at
+	// the egress proxy.
+	env []string
+}
+
+// newSandbox creates and starts a sandbox for the language under the OCI
+// runtime. The sandbox starts out with the language's run limits and security
+// profile. Without a network it has no network access at all.
+func newSandbox(ctx context.Context, cli *client.Client, lang *language.Language, runtime string, network *sandboxNetwork) (*sandbox, error) {
+	dir, err := os.MkdirTemp("", "code")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	sb := &sandbox{cli: cli, dir: dir, lang: lang, runtime: runtime, limits: lang.Limits}
+
+	networkMode := container.NetworkMode("none")
+	env := append([]string{"HOME=/tmp"}, lang.Env...)
+	if network != nil {
+		networkMode = container.NetworkMode(network.name)
+		env = append(env, network.env...)
+	}
+	security := lang.Security
+	hostConfig := &container.HostConfig{
+		NetworkMode: networkMode,
+		Runtime:     runtime,
+		Resources:   resources(lang.Limits),
+		// Output is read through exec and never reaches the container's
+		// logs, which would otherwise grow on the host's disk
+		LogConfig:      container.LogConfig{Type: "none"},
+		ReadonlyRootfs: !security.WritableRoot,
+		CapDrop:        []string{"ALL"},
+		CapAdd:         security.CapAdd,
+		SecurityOpt:    securityOptions(security),
+		Tmpfs: map[string]string{
+			workDir: fmt.Sprintf("rw,exec,nosuid,nodev,size=%dm,mode=1777", security.WorkDirMB),
+			"/tmp":  fmt.Sprintf("rw,exec,nosuid,nodev,size=%dm,mode=1777", security.TmpMB),
+		},
+	}
+	// Ulimits cannot be updated, unlike the rest of the resources
+	hostConfig.Ulimits = ulimits(security.Ulimits)
+	created := time.Now()
+	resp, err := cli.ContainerCreate(ctx, &container.Config{
+		Image:           lang.Image,
+		Entrypoint:      idleCommand,
+		User:            security.User,
+		WorkingDir:      workDir,
+		Env:             env,
+		NetworkDisabled: network == nil,
+		Labels:          map[string]string{sandboxLabel: lang.Name},
+	}, hostConfig, nil, nil, "")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create container: %w", err)
+	}
+	sb.id = resp.ID
+	sb.createTime = time.Since(created)
+
+	started := time.Now()
+	if err := cli.ContainerStart(ctx, sb.id, container.StartOptions{}); err != nil {
+		sb.destroy()
+		return nil, fmt.Errorf("failed to start container: %w", err)
+	}
+	sb.startTime = time.Since(started)
+	return sb, nil
+}
+
+// securityOptions returns the Docker security options of a profile.
+func securityOptions(security language.Security) []string {
+	var options []string
+	if !security.AllowNewPrivileges {
+		options = append(options, "no-new-privileges:true")
+	}
+	switch security.Seccomp {
+	case language.SeccompBundled:
+		options = append(options, "seccomp="+seccompProfile)
+	case language.SeccompUnconfined:
+		options = append(options, "seccomp=unconfined")
+	}
+	return options
+}
+
+// ulimits converts a profile's ulimits, which set the soft and hard limit
+// alike. nproc should be avoided: it counts every process of the user across
+// all containers, whereas the pids limit is per sandbox.
+func ulimits(limits map[string]int64) []*units.Ulimit {
+	names := make([]string, 0, len(limits))
+	for name := range limits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ulimits := make([]*units.Ulimit, len(names))
+	for i, name := range names {
+		ulimits[i] = &units.Ulimit{Name: name, Soft: limits[name], Hard: limits[name]}
+	}
+	return ulimits
+}
+
+// healthy reports whether the sandbox's container is still running.
+func (sb *sandbox) healthy(ctx context.Context) bool {
+	info, err := sb.cli.ContainerInspect(ctx, sb.id)
+	return err == nil && info.State != nil && info.State.Running
+}
+
+// exec runs a command in the sandbox under the given limits and collects
+// its output. A command that is still running when the timeout elapses or
+// ctx is cancelled is stopped by killing the whole sandbox, which can then
+// no longer be used. A timeout is reported through the timedOut flag; a
+// cancelled ctx is reported as an error.
+func (sb *sandbox) exec(ctx context.Context, spec execSpec) (*execRun, error) {
+	if err := sb.setLimits(ctx, spec.limits); err != nil {
+		return nil, err
+	}
+
+	created, err := sb.cli.ContainerExecCreate(ctx, sb.id, types.ExecConfig{
+		Cmd:          spec.cmd,
+		User:         spec.user,
+		WorkingDir:   workDir,
+		AttachStdin:  spec.stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	var before usage
+	var beforeOK bool
+	if !spec.noStats {
+		before, beforeOK = sb.usage()
+	}
+	started := time.Now()
+	attach, err := sb.cli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start exec: %w", err)
+	}
+	defer attach.Close()
+
+	if spec.stdin != nil {
+		go func() {
+			// Blocks until the program has consumed its input; killing the
+			// sandbox on timeout unblocks it
+			io.Copy(attach.Conn, spec.stdin)
+			attach.CloseWrite()
+		}()
+	}
+
+	stdout := &limitedBuffer{limit: spec.limits.OutputBytes}
+	stderr := &limitedBuffer{limit: spec.limits.OutputBytes}
+	if spec.output != nil {
+		stdout.output = func(p []byte) { spec.output(Stdout, p) }
+		stderr.output = func(p []byte) { spec.output(Stderr, p) }
+	}
+	var stdoutWriter io.Writer = stdout
+	if spec.stdout != nil {
+		stdoutWriter = spec.stdout
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(stdoutWriter, stderr, attach.Reader)
+		done <- err
+	}()
+
+	timer := time.NewTimer(time.Duration(spec.limits.Timeout))
+	defer timer.Stop()
+	var sampler *usageSampler
+	if !spec.noStats {
+		sampler = sb.sampleUsage()
+		defer sampler.finish()
+	}
+
+	run := &execRun{}
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, fmt.Errorf("failed to read exec output: %w", err)
+		}
+		run.wallTime = time.Since(started)
+	case <-timer.C:
+		sb.kill()
+		<-done
+		run.wallTime = time.Since(started)
+		run.exitCode = -1
+		run.timedOut = true
+	case <-ctx.Done():
+		sb.kill()
+		<-done
+		return nil, fmt.Errorf("execution cancelled: %w", ctx.Err())
+	}
+	if sampler != nil {
+		run.measureUsage(sb, sampler, before, beforeOK)
+	}
+
+	if !run.timedOut {
+		inspect, err := sb.cli.ContainerExecInspect(ctx, created.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect exec: %w", err)
+		}
+		run.exitCode = inspect.ExitCode
+		oomKilled, err := sb.checkOOMKilled(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// Docker misses OOM kills under some runtimes, so a SIGKILL counts
+		// too, unless the program was far from its limit: it then killed
+		// itself, or a child propagated the signal
+		nearLimit := float64(run.peakMemory) >= oomPeakRatio*float64(spec.limits.MemoryMB<<20)
+		run.oomKilled = oomKilled || (run.exitCode == 137 && nearLimit)
+	}
+	if limit := spec.limits.MemoryMB << 20; run.oomKilled && run.peakMemory < limit {
+		// The sample before the kill is usually missed
+		run.peakMemory = limit
+	}
+	run.stdout = stdout.String()
+	run.stderr = stderr.String()
+	run.stdoutBytes = stdout.total
+	run.stderrBytes = stderr.total
+	return run, nil
+}
+
+// measureUsage stops the sampler and records the command's peak memory and
+// CPU time. The CPU time is the difference between samples taken before and
+// after the command; a sandbox killed on timeout can no longer be sampled, so
+// the sampler's latest sample is used instead.
+func (r *execRun) measureUsage(sb *sandbox, sampler *usageSampler, before usage, beforeOK bool) {
+	peak, after, ok := sampler.finish()
+	if !r.timedOut {
+		if final, finalOK := sb.usage(); finalOK {
+			after, ok = final, true
+		}
+	}
+	r.peakMemory = peak
+	if beforeOK && ok {
+		r.cpuUser = after.cpuUser - before.cpuUser
+		r.cpuSystem = after.cpuSystem - before.cpuSystem
+	}
+}
+
+// setLimits updates the sandbox's memory, CPU and process limits if they
+// differ from the current ones. The timeout and output limits are enforced by
+// exec itself.
+func (sb *sandbox) setLimits(ctx context.Context, limits language.Limits) error {
+	if limits.MemoryMB == sb.limits.MemoryMB && limits.CPUs == sb.limits.CPUs && limits.Pids == sb.limits.Pids {
+		return nil
+	}
+	_, err := sb.cli.ContainerUpdate(ctx, sb.id, container.UpdateConfig{Resources: resources(limits)})
+	if err != nil {
+		return fmt.Errorf("failed to update container limits: %w", err)
+	}
+	sb.limits = limits
+	return nil
+}
+
+// resources converts limits to the container's resource settings. Swap is
+// disabled by setting it to the memory limit.
+func resources(limits language.Limits) container.Resources {
+	memory := limits.MemoryMB * 1024 * 1024
+	pids := limits.Pids
+	return container.Resources{
+		Memory:     memory,
+		MemorySwap: memory,
+		NanoCPUs:   int64(limits.CPUs * 1e9),
+		PidsLimit:  &pids,
+	}
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards the
+// rest, so that a chatty program cannot exhaust the server's memory. It counts
+// every byte written, kept or not, and passes the kept bytes on to output, if
+// set.
+type limitedBuffer struct {
+	strings.Builder
+	limit  int64
+	total  int64
+	output func(p []byte)
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	b.total += int64(len(p))
+	kept := p
+	if room := b.limit - int64(b.Len()); room < int64(len(p)) {
+		kept = p[:max(room, 0)]
+	}
+	if len(kept) == 0 {
+		return len(p), nil
+	}
+	b.Builder.Write(kept)
+	if b.output != nil {
+		b.output(kept)
+	}
+	return len(p), nil
+}
+
+// checkOOMKilled reports whether the container was OOM killed since the
+// previous check. Docker keeps the flag set once a process was killed.
+func (sb *sandbox) checkOOMKilled(ctx context.Context) (bool, error) {
+	info, err := sb.cli.ContainerInspect(ctx, sb.id)
+	if err != nil {
+		return false, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	oomKilled := info.State != nil && info.State.OOMKilled
+	killed := oomKilled && !sb.oomKilled
+	sb.oomKilled = oomKilled
+	return killed, nil
+}
+
+// kill stops the sandbox's container and every command running in it.
+func (sb *sandbox) kill() {
+	killContainer(sb.cli, sb.id)
+}
+
+// destroy removes the sandbox's container and host directory.
+func (sb *sandbox) destroy() {
+	if sb.id != "" {
+		removeContainer(sb.cli, sb.id)
+	}
+	os.RemoveAll(sb.dir)
+}
@@ -0,0 +1,172 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// sessionPollInterval is how often Wait checks whether a session's program
+// has exited once its output has ended.
+const sessionPollInterval = 50 * time.Millisecond
+
+// Session is a program running interactively under a pseudo-terminal, so
+// that it behaves as it would in a terminal: its output is not buffered, and
+// its stdout and stderr are interleaved on a single stream.
+type Session interface {
+	// Read reads the program's terminal output. It returns io.EOF once the
+	// program has exited.
+	io.Reader
+	// Write writes to the program's terminal input, as if typed.
+	io.Writer
+	// Resize changes the size of the terminal.
+	Resize(rows, cols uint) error
+	// Wait returns the program's exit code once its output has ended.
+	Wait() (int, error)
+	// Close kills the program if it is still running and releases its
+	// sandbox.
+	Close() error
+}
+
+// SessionExecutor is implemented by executors that can run programs
+// interactively.
+type SessionExecutor interface {
+	// StartSession prepares a submission as Execute does and starts its
+	// program interactively. A submission rejected by its syntax check or
+	// compiler is reported by a result instead of a session. The request's
+	// memory, CPU and process limits apply, but not its timeout: the session
+	// lasts until its program exits, it is closed or ctx is done.
+	StartSession(ctx context.Context, req Request) (Session, *Result, error)
+}
+
+var _ SessionExecutor = (*DockerExecutor)(nil)
+
+func (e *DockerExecutor) StartSession(ctx context.Context, req Request) (Session, *Result, error) {
+	lang, ok := e.languages.Lookup(req.Language)
+	if !ok {
+		return nil, nil, fmt.Errorf("unsupported language: %s", req.Language)
+	}
+	limits := lang.Limits.Override(req.Limits)
+
+	sb, release, err := e.acquireSandbox(ctx, lang, req.Network)
+	if err != nil {
+		return nil, nil, err
+	}
+	result := &Result{
+		SyntaxCheck: SyntaxCheck{Passed: true},
+		Limits:      resultLimits(limits),
+		Runtime:     sb.runtime,
+	}
+	entrypoint, ok, err := prepare(ctx, sb, lang, limits, req, result)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	if !ok {
+		release()
+		return nil, result, nil
+	}
+
+	session, err := startSession(ctx, sb, expandCommand(lang.Run, entrypoint), limits)
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	session.release = release
+	return session, nil, nil
+}
+
+// dockerSession is a command attached to a terminal in a sandbox.
+type dockerSession struct {
+	sb      *sandbox
+	execID  string
+	attach  types.HijackedResponse
+	release func()
+	// stopKill stops killing the sandbox when the session's context is done.
+	stopKill  func() bool
+	closeOnce sync.Once
+}
+
+// startSession runs cmd in the sandbox attached to a terminal. The sandbox
+// is killed when ctx is done.
+func startSession(ctx context.Context, sb *sandbox, cmd []string, limits language.Limits) (*dockerSession, error) {
+	if err := sb.setLimits(ctx, limits); err != nil {
+		return nil, err
+	}
+	created, err := sb.cli.ContainerExecCreate(ctx, sb.id, types.ExecConfig{
+		Cmd:          cmd,
+		WorkingDir:   workDir,
+		Env:          []string{"TERM=xterm"},
+		Tty:          true,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create exec: %w", err)
+	}
+	attach, err := sb.cli.ContainerExecAttach(ctx, created.ID, types.ExecStartCheck{Tty: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start exec: %w", err)
+	}
+	return &dockerSession{
+		sb:       sb,
+		execID:   created.ID,
+		attach:   attach,
+		stopKill: context.AfterFunc(ctx, sb.kill),
+	}, nil
+}
+
+func (s *dockerSession) Read(p []byte) (int, error) {
+	return s.attach.Reader.Read(p)
+}
+
+func (s *dockerSession) Write(p []byte) (int, error) {
+	return s.attach.Conn.Write(p)
+}
+
+func (s *dockerSession) Resize(rows, cols uint) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if err := s.sb.cli.ContainerExecResize(ctx, s.execID, container.ResizeOptions{Height: rows, Width: cols}); err != nil {
+		return fmt.Errorf("failed to resize terminal: %w", err)
+	}
+	return nil
+}
+
+func (s *dockerSession) Wait() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	ticker := time.NewTicker(sessionPollInterval)
+	defer ticker.Stop()
+	for {
+		inspect, err := s.sb.cli.ContainerExecInspect(ctx, s.execID)
+		if err != nil {
+			return -1, fmt.Errorf("failed to inspect exec: %w", err)
+		}
+		if !inspect.Running {
+			return inspect.ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+			return -1, fmt.Errorf("failed to wait for exit: %w", ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *dockerSession) Close() error {
+	s.closeOnce.Do(func() {
+		s.stopKill()
+		s.attach.Close()
+		s.release()
+	})
+	return nil
+}
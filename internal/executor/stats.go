@@ -0,0 +1,106 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+const (
+	// usagePollInterval is how often the resource usage of a sandbox is
+	// sampled while a command runs.
+	usagePollInterval = 100 * time.Millisecond
+	// usageTimeout bounds reading a single sample.
+	usageTimeout = time.Second
+)
+
+// usage is a sample of a sandbox's resource usage, read from the container's
+// cgroup through Docker stats. CPU times are cumulative since the container
+// started.
+type usage struct {
+	memory    int64
+	cpuUser   time.Duration
+	cpuSystem time.Duration
+}
+
+// usageSampler polls a sandbox's usage until stopped, recording the highest
+// memory usage and the latest sample. Docker reports no per-command memory
+// peak on cgroup v2, so short spikes between samples can be missed.
+type usageSampler struct {
+	sb       *sandbox
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+
+	peakMemory int64
+	last       usage
+	sampled    bool
+}
+
+func (sb *sandbox) sampleUsage() *usageSampler {
+	s := &usageSampler{sb: sb, stop: make(chan struct{})}
+	s.wg.Add(1)
+	go s.run()
+	return s
+}
+
+func (s *usageSampler) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(usagePollInterval)
+	defer ticker.Stop()
+	for {
+		if sample, ok := s.sb.usage(); ok {
+			s.peakMemory = max(s.peakMemory, sample.memory)
+			s.last = sample
+			s.sampled = true
+		}
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// finish stops sampling and returns the peak memory usage in bytes and the
+// latest sample, if any. It may be called more than once.
+func (s *usageSampler) finish() (int64, usage, bool) {
+	s.stopOnce.Do(func() { close(s.stop) })
+	s.wg.Wait()
+	return s.peakMemory, s.last, s.sampled
+}
+
+// usage reads the sandbox's current usage. Memory excludes the page cache
+// the kernel can reclaim, as docker stats does.
+func (sb *sandbox) usage() (usage, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), usageTimeout)
+	defer cancel()
+	resp, err := sb.cli.ContainerStatsOneShot(ctx, sb.id)
+	if err != nil {
+		return usage{}, false
+	}
+	defer resp.Body.Close()
+
+	var stats types.StatsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return usage{}, false
+	}
+	memory := stats.MemoryStats.Usage
+	// cgroup v2 and v1 name the inactive page cache differently
+	for _, key := range []string{"inactive_file", "total_inactive_file"} {
+		if inactive, ok := stats.MemoryStats.Stats[key]; ok && inactive < memory {
+			memory -= inactive
+			break
+		}
+	}
+	cpu := stats.CPUStats.CPUUsage
+	return usage{
+		memory:    int64(memory),
+		cpuUser:   time.Duration(cpu.UsageInUsermode),
+		cpuSystem: time.Duration(cpu.UsageInKernelmode),
+	}, stats.Read.After(time.Time{})
+}
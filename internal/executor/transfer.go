@@ -0,0 +1,196 @@
+package executor
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// copyTimeout bounds copying files in or out of a sandbox.
+const copyTimeout = 30 * time.Second
+
+// copyIn copies the files of the sandbox's host directory into its working
+// directory. Files are owned by root and read-only to the program, and
+// directories are sticky and world-writable, so that the program can create
+// files next to the submitted ones but cannot replace them. Of the files'
+// modes, only their executable bit is kept.
+func (sb *sandbox) copyIn(ctx context.Context) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(packWorkspace(sb.dir, pw))
+	}()
+	run, err := sb.exec(ctx, execSpec{
+		cmd:     []string{"tar", "-x", "-f", "-", "-C", workDir},
+		limits:  sb.copyLimits(),
+		user:    "0:0",
+		stdin:   pr,
+		noStats: true,
+	})
+	// Unblocks packWorkspace if tar did not read the whole archive
+	pr.Close()
+	if err != nil {
+		return fmt.Errorf("failed to copy files into sandbox: %w", err)
+	}
+	if run.timedOut || run.exitCode != 0 {
+		return fmt.Errorf("failed to copy files into sandbox: %s", strings.TrimSpace(run.stderr))
+	}
+	return nil
+}
+
+// copyOut replaces the files of the sandbox's host directory with the
+// regular files of its working directory. Files the sandbox user cannot read
+// are left out, in which case, as whenever tar fails, it reports false: the
+// host directory then holds only the files archived before the failure.
+func (sb *sandbox) copyOut(ctx context.Context) (bool, error) {
+	if err := os.RemoveAll(sb.dir); err != nil {
+		return false, fmt.Errorf("failed to clear %s: %w", sb.dir, err)
+	}
+	if err := os.Mkdir(sb.dir, 0700); err != nil {
+		return false, fmt.Errorf("failed to create %s: %w", sb.dir, err)
+	}
+
+	pr, pw := io.Pipe()
+	unpacked := make(chan error, 1)
+	go func() {
+		err := unpackWorkspace(pr, sb.dir, sb.lang.Security.WorkDirMB<<20)
+		if err == nil {
+			// Drain the padding tar writes after the last entry
+			io.Copy(io.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		unpacked <- err
+	}()
+	run, err := sb.exec(ctx, execSpec{
+		cmd:     []string{"tar", "-c", "-f", "-", "-C", workDir, "."},
+		limits:  sb.copyLimits(),
+		stdout:  pw,
+		noStats: true,
+	})
+	pw.Close()
+	unpackErr := <-unpacked
+	switch {
+	case err != nil:
+		return false, fmt.Errorf("failed to copy files out of sandbox: %w", err)
+	case run.timedOut:
+		return false, fmt.Errorf("failed to copy files out of sandbox: timed out after %s", copyTimeout)
+	case unpackErr != nil:
+		return false, fmt.Errorf("failed to copy files out of sandbox: %w", unpackErr)
+	}
+	return run.exitCode == 0, nil
+}
+
+// copyLimits are the sandbox's current limits with the copy timeout, so that
+// copying does not update the container.
+func (sb *sandbox) copyLimits() language.Limits {
+	limits := sb.limits
+	limits.Timeout = language.Duration(copyTimeout)
+	return limits
+}
+
+// packWorkspace writes the directories and regular files below dir to w as a
+// tar archive of root-owned entries.
+func packWorkspace(dir string, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil || name == "." {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		header := &tar.Header{Name: filepath.ToSlash(name), ModTime: info.ModTime()}
+		switch {
+		case info.IsDir():
+			header.Typeflag = tar.TypeDir
+			header.Name += "/"
+			header.Mode = 01777
+		case info.Mode().IsRegular():
+			header.Typeflag = tar.TypeReg
+			header.Mode = 0644
+			if info.Mode()&0111 != 0 {
+				header.Mode = 0755
+			}
+			header.Size = info.Size()
+		default:
+			return nil
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pack workspace: %w", err)
+	}
+	return tw.Close()
+}
+
+// unpackWorkspace extracts the regular files of a tar archive below dir, up
+// to limit bytes in total, keeping only their executable bit. Other entries
+// are skipped, so that a program cannot make the server follow symlinks out
+// of dir.
+func unpackWorkspace(r io.Reader, dir string, limit int64) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, err := cleanPath(header.Name)
+		if err != nil {
+			continue
+		}
+		if header.Size > limit {
+			return errors.New("files exceed the working directory size")
+		}
+		limit -= header.Size
+
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		mode := os.FileMode(0644)
+		if header.Mode&0111 != 0 {
+			mode = 0755
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
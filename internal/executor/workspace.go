@@ -0,0 +1,208 @@
+package executor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// maxWorkspaceSize bounds the total size of the files materialized for a
+	// submission, including those extracted from its archive.
+	maxWorkspaceSize = 32 << 20
+	// maxWorkspaceFiles bounds the number of files materialized for a
+	// submission.
+	maxWorkspaceFiles = 1000
+)
+
+// ErrInvalidRequest is wrapped by errors caused by a malformed submission
+// rather than by the execution backend.
+var ErrInvalidRequest = errors.New("invalid request")
+
+// Validate checks the paths of a request's files and entrypoint. Archive
+// entries are checked when the archive is extracted.
+func (r Request) Validate() error {
+	for name := range r.Files {
+		if _, err := cleanPath(name); err != nil {
+			return err
+		}
+	}
+	if r.Entrypoint != "" {
+		if _, err := cleanPath(r.Entrypoint); err != nil {
+			return err
+		}
+	}
+	if r.Code == "" && len(r.Files) == 0 && len(r.Archive) == 0 {
+		return fmt.Errorf("%w: no code, files or archive provided", ErrInvalidRequest)
+	}
+	return nil
+}
+
+// MaterializeWorkspace writes a submission into dir: the archive is extracted
+// first, then Files are written, then Code is written to the entrypoint. It
+// returns the entrypoint, which defaults to defaultEntrypoint and must exist
+// once the workspace is written.
+func MaterializeWorkspace(dir, defaultEntrypoint string, req Request) (string, error) {
+	if err := req.Validate(); err != nil {
+		return "", err
+	}
+
+	entrypoint := defaultEntrypoint
+	if req.Entrypoint != "" {
+		entrypoint, _ = cleanPath(req.Entrypoint)
+	}
+
+	w := &workspaceWriter{dir: dir}
+	if err := w.writeFiles(req); err != nil {
+		return "", err
+	}
+	if req.Code != "" {
+		if err := w.writeFile(entrypoint, strings.NewReader(req.Code)); err != nil {
+			return "", err
+		}
+	}
+
+	info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(entrypoint)))
+	if err != nil || !info.Mode().IsRegular() {
+		return "", fmt.Errorf("%w: entrypoint %s not found", ErrInvalidRequest, entrypoint)
+	}
+	return entrypoint, nil
+}
+
+// workspaceWriter writes files below dir while enforcing the workspace caps.
+type workspaceWriter struct {
+	dir   string
+	size  int64
+	files int
+}
+
+// writeFiles extracts a request's archive, then writes its Files.
+func (w *workspaceWriter) writeFiles(req Request) error {
+	if len(req.Archive) > 0 {
+		if err := w.extract(req.Archive); err != nil {
+			return err
+		}
+	}
+	for name, content := range req.Files {
+		if err := w.writeFile(name, strings.NewReader(content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *workspaceWriter) writeFile(name string, r io.Reader) error {
+	cleaned, err := cleanPath(name)
+	if err != nil {
+		return err
+	}
+	w.files++
+	if w.files > maxWorkspaceFiles {
+		return fmt.Errorf("%w: more than %d files", ErrInvalidRequest, maxWorkspaceFiles)
+	}
+
+	target := filepath.Join(w.dir, filepath.FromSlash(cleaned))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", cleaned, err)
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", cleaned, err)
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(r, maxWorkspaceSize-w.size+1))
+	w.size += n
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", cleaned, err)
+	}
+	if w.size > maxWorkspaceSize {
+		return fmt.Errorf("%w: files exceed %d bytes", ErrInvalidRequest, maxWorkspaceSize)
+	}
+	return nil
+}
+
+// extract unpacks a zip, tar or gzip-compressed tar archive.
+func (w *workspaceWriter) extract(archive []byte) error {
+	switch {
+	case bytes.HasPrefix(archive, []byte("PK\x03\x04")):
+		return w.extractZip(archive)
+	case bytes.HasPrefix(archive, []byte("\x1f\x8b")):
+		gz, err := gzip.NewReader(bytes.NewReader(archive))
+		if err != nil {
+			return fmt.Errorf("%w: invalid archive: %v", ErrInvalidRequest, err)
+		}
+		defer gz.Close()
+		return w.extractTar(gz)
+	default:
+		return w.extractTar(bytes.NewReader(archive))
+	}
+}
+
+func (w *workspaceWriter) extractZip(archive []byte) error {
+	zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		return fmt.Errorf("%w: invalid archive: %v", ErrInvalidRequest, err)
+	}
+	for _, entry := range zr.File {
+		mode := entry.Mode()
+		switch {
+		case mode.IsDir():
+			continue
+		case !mode.IsRegular():
+			return fmt.Errorf("%w: archive entry %s is not a regular file", ErrInvalidRequest, entry.Name)
+		}
+		rc, err := entry.Open()
+		if err != nil {
+			return fmt.Errorf("%w: invalid archive entry %s: %v", ErrInvalidRequest, entry.Name, err)
+		}
+		err = w.writeFile(entry.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *workspaceWriter) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: invalid archive: %v", ErrInvalidRequest, err)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeReg:
+			if err := w.writeFile(header.Name, tr); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("%w: archive entry %s is not a regular file", ErrInvalidRequest, header.Name)
+		}
+	}
+}
+
+// cleanPath normalizes a slash-separated path and rejects paths that would
+// escape the workspace.
+func cleanPath(name string) (string, error) {
+	cleaned := path.Clean(strings.TrimPrefix(name, "./"))
+	if name == "" || strings.Contains(name, "\\") || path.IsAbs(cleaned) ||
+		cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%w: invalid file path %q", ErrInvalidRequest, name)
+	}
+	return cleaned, nil
+}
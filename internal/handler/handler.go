@@ -1,23 +1,57 @@
 package handler
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/isavita/codeexec/internal/auth"
+	"github.com/isavita/codeexec/internal/egress"
 	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/language"
 )
 
+const (
+	// maxStdinSize is the largest stdin a submission may provide.
+	maxStdinSize = 1 << 20
+	// maxRequestSize bounds the request body, which may carry a whole
+	// project.
+	maxRequestSize = 64 << 20
+)
+
+var errNetworkNotPermitted = errors.New("network access is not permitted for this API key")
+
+// executeRequest is the body of /api/execute and /api/jobs.
+type executeRequest struct {
+	Code       string            `json:"code"`
+	Language   string            `json:"language"`
+	Stdin      string            `json:"stdin"`
+	Files      map[string]string `json:"files"`
+	Archive    string            `json:"archive"`
+	Entrypoint string            `json:"entrypoint"`
+	// Limits are the requested limits of the run. Omitted or zero fields
+	// select the language's defaults.
+	Limits executor.Limits `json:"limits"`
+	// Network asks for access to the allowed hosts.
+	Network *networkRequest `json:"network"`
+	// CallbackURL receives the finished job. Only jobs accept it.
+	CallbackURL string `json:"callback_url"`
+}
+
+type networkRequest struct {
+	AllowedHosts []string `json:"allowed_hosts"`
+}
+
 type CodeExecutionHandler struct {
-	executor *executor.DockerExecutor
+	executor  executor.Executor
+	languages *language.Registry
 }
 
-func NewCodeExecutionHandler() *CodeExecutionHandler {
-	exec, err := executor.NewDockerExecutor()
-	if err != nil {
-		panic(err)
-	}
-	return &CodeExecutionHandler{executor: exec}
+func NewCodeExecutionHandler(exec executor.Executor, languages *language.Registry) *CodeExecutionHandler {
+	return &CodeExecutionHandler{executor: exec, languages: languages}
 }
 
 func (h *CodeExecutionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -26,57 +60,165 @@ func (h *CodeExecutionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var body map[string]string
-	err := json.NewDecoder(r.Body).Decode(&body)
+	var body executeRequest
+	req, ok := parseRequest(w, r, h.languages, &body)
+	if !ok {
+		return
+	}
+	if body.CallbackURL != "" {
+		errorResponse(w, "callback_url is only supported by /api/jobs", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.executor.Execute(r.Context(), req)
+	if errors.Is(err, executor.ErrInvalidRequest) {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
-		errorResponse(w, "invalid request body", http.StatusBadRequest)
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	code := body["code"]
-	language := body["language"]
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
 
-	if language == "" {
-		errorResponse(w, "language not specified", http.StatusBadRequest)
-		return
+// parseRequest decodes and validates an execute request body into body,
+// resolving its limits and network access against the language and the
+// request's API key. It writes an error response and returns false if the
+// request is invalid.
+func parseRequest(w http.ResponseWriter, r *http.Request, languages *language.Registry, body *executeRequest) (executor.Request, bool) {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(body)
+	if err != nil {
+		errorResponse(w, "invalid request body", http.StatusBadRequest)
+		return executor.Request{}, false
 	}
 
-	if !isLanguageSupported(language) {
-		errorResponse(w, "unsupported language: "+language, http.StatusBadRequest)
-		return
+	req, reqErr := newRequest(r, languages, body)
+	if reqErr != nil {
+		errorResponse(w, reqErr.message, reqErr.status)
+		return executor.Request{}, false
 	}
+	return req, true
+}
 
-	if code == "" {
-		errorResponse(w, "code not provided", http.StatusBadRequest)
-		return
+// requestError is an invalid request and the status code reporting it.
+type requestError struct {
+	status  int
+	message string
+}
+
+// newRequest validates a decoded request body, resolving its limits and
+// network access against the language and the request's API key.
+func newRequest(r *http.Request, languages *language.Registry, body *executeRequest) (executor.Request, *requestError) {
+	if body.Language == "" {
+		return executor.Request{}, &requestError{http.StatusBadRequest, "language not specified"}
 	}
 
-	output, err := h.executor.Execute(code, language, 5*time.Second)
+	lang, ok := languages.Lookup(body.Language)
+	if !ok {
+		return executor.Request{}, &requestError{http.StatusBadRequest, "unsupported language: " + body.Language}
+	}
+
+	if body.Code == "" && len(body.Files) == 0 && body.Archive == "" {
+		return executor.Request{}, &requestError{http.StatusBadRequest, "code not provided"}
+	}
+
+	req, reqErr := resolveRequest(r, lang, body)
+	if reqErr != nil {
+		return executor.Request{}, reqErr
+	}
+	if err := req.Validate(); err != nil {
+		return executor.Request{}, &requestError{http.StatusBadRequest, err.Error()}
+	}
+	return req, nil
+}
+
+// resolveRequest converts a request body for the language, resolving its
+// limits and network access against the request's API key. Its files are
+// checked once they are written.
+func resolveRequest(r *http.Request, lang *language.Language, body *executeRequest) (executor.Request, *requestError) {
+	if len(body.Stdin) > maxStdinSize {
+		return executor.Request{}, &requestError{http.StatusRequestEntityTooLarge, fmt.Sprintf("stdin exceeds %d bytes", maxStdinSize)}
+	}
+
+	archive, err := base64.StdEncoding.DecodeString(body.Archive)
 	if err != nil {
-		response := map[string]string{
-			"error": err.Error(),
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(response)
-		return
+		return executor.Request{}, &requestError{http.StatusBadRequest, "archive is not valid base64"}
 	}
 
-	response := map[string]string{
-		"output": output,
+	key, _ := auth.FromContext(r.Context())
+	var ceiling language.Limits
+	if key != nil {
+		ceiling = key.MaxLimits
+	}
+	limits, err := lang.ResolveLimits(requestLimits(body.Limits), ceiling)
+	if err != nil {
+		return executor.Request{}, &requestError{http.StatusBadRequest, err.Error()}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(response)
+	network, err := requestNetwork(body.Network, key)
+	if errors.Is(err, errNetworkNotPermitted) || errors.Is(err, egress.ErrNotPermitted) {
+		return executor.Request{}, &requestError{http.StatusForbidden, err.Error()}
+	}
+	if err != nil {
+		return executor.Request{}, &requestError{http.StatusBadRequest, err.Error()}
+	}
+
+	return executor.Request{
+		Language:   body.Language,
+		Code:       body.Code,
+		Files:      body.Files,
+		Archive:    archive,
+		Entrypoint: body.Entrypoint,
+		Stdin:      body.Stdin,
+		Limits:     limits,
+		Network:    network,
+	}, nil
+}
+
+// requestLimits converts limits requested by a client.
+func requestLimits(limits executor.Limits) language.Limits {
+	return language.Limits{
+		Timeout:     language.Duration(time.Duration(limits.TimeoutMs) * time.Millisecond),
+		MemoryMB:    limits.MemoryMB,
+		CPUs:        limits.CPUs,
+		Pids:        limits.Pids,
+		OutputBytes: limits.OutputBytes,
+	}
 }
 
-func isLanguageSupported(language string) bool {
-	supportedLanguages := []string{"python", "javascript"}
-	for _, lang := range supportedLanguages {
-		if lang == language {
-			return true
+// requestNetwork resolves the network access of a request. Requests made
+// with an API key are bound by the key's network policy; the executor
+// enforces the server's own allowlist in any case.
+func requestNetwork(requested *networkRequest, key *auth.Key) (*executor.Network, error) {
+	if key == nil {
+		if requested == nil {
+			return nil, nil
 		}
+		return &executor.Network{AllowedHosts: requested.AllowedHosts}, nil
 	}
-	return false
+
+	policy := key.Network
+	switch {
+	case requested == nil && policy != nil && policy.Default:
+		return &executor.Network{AllowedHosts: policy.AllowedHosts}, nil
+	case requested == nil:
+		return nil, nil
+	case policy == nil:
+		return nil, errNetworkNotPermitted
+	}
+	if err := egress.Check(policy.AllowedHosts, requested.AllowedHosts); err != nil {
+		return nil, err
+	}
+	return &executor.Network{AllowedHosts: requested.AllowedHosts}, nil
+}
+
+func jsonResponse(w http.ResponseWriter, body any, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(body)
 }
 
 func errorResponse(w http.ResponseWriter, message string, statusCode int) {
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+const (
+	// defaultIdleTimeout is used when InteractiveConfig leaves IdleTimeout
+	// unset.
+	defaultIdleTimeout = 2 * time.Minute
+	// defaultMaxDuration is used when InteractiveConfig leaves MaxDuration
+	// unset.
+	defaultMaxDuration = 10 * time.Minute
+	// maxMessageSize bounds the messages a client sends after its
+	// submission.
+	maxMessageSize = 64 << 10
+	// sessionWriteTimeout bounds sending a message to the client.
+	sessionWriteTimeout = 10 * time.Second
+	// sessionReadSize is the largest chunk of output sent in one message.
+	sessionReadSize = 32 << 10
+)
+
+// Reasons an interactive session ended.
+const (
+	reasonExited      = "exited"
+	reasonClosed      = "closed"
+	reasonIdleTimeout = "idle_timeout"
+	reasonMaxDuration = "max_duration"
+)
+
+// InteractiveConfig configures interactive sessions.
+type InteractiveConfig struct {
+	// IdleTimeout ends sessions without any input or output for that long.
+	// Defaults to 2 minutes.
+	IdleTimeout time.Duration
+	// MaxDuration ends sessions that last that long. Defaults to 10
+	// minutes.
+	MaxDuration time.Duration
+	// AllowedOrigins are the origins of the web pages allowed to open
+	// sessions, such as "https://editor.example.com", or "*" for any.
+	// Defaults to pages served from the API's own host.
+	AllowedOrigins []string
+}
+
+// InteractiveHandler serves GET /api/interactive, which upgrades to a
+// WebSocket running a program interactively under a terminal. The client's
+// first message is a submission, as for /api/execute; the program's terminal
+// output is then relayed to the client and the client's keystrokes to the
+// program, until the program exits, the client closes the session, or the
+// session idles or runs for too long.
+type InteractiveHandler struct {
+	executor  executor.SessionExecutor
+	languages *language.Registry
+	config    InteractiveConfig
+	upgrader  websocket.Upgrader
+}
+
+func NewInteractiveHandler(exec executor.SessionExecutor, languages *language.Registry, config InteractiveConfig) *InteractiveHandler {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaultIdleTimeout
+	}
+	if config.MaxDuration <= 0 {
+		config.MaxDuration = defaultMaxDuration
+	}
+	h := &InteractiveHandler{executor: exec, languages: languages, config: config}
+	if len(config.AllowedOrigins) > 0 {
+		h.upgrader.CheckOrigin = func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || slices.Contains(config.AllowedOrigins, "*") || slices.Contains(config.AllowedOrigins, origin)
+		}
+	}
+	return h
+}
+
+// sessionMessage is a message of an interactive session. Clients send
+// "input" with data typed, "resize" with the terminal's rows and cols, and
+// "close". The server sends "started" once the program runs, "output" with
+// data written, and finally "exit" with the exit code and the reason the
+// session ended, "rejected" with the result of a submission rejected by its
+// syntax check or compiler, or "error".
+type sessionMessage struct {
+	Type     string           `json:"type"`
+	Data     string           `json:"data,omitempty"`
+	Rows     uint             `json:"rows,omitempty"`
+	Cols     uint             `json:"cols,omitempty"`
+	ExitCode *int             `json:"exit_code,omitempty"`
+	Reason   string           `json:"reason,omitempty"`
+	Result   *executor.Result `json:"result,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+func (h *InteractiveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader has responded already
+		return
+	}
+	conn := &sessionConn{ws: ws}
+	defer conn.close()
+
+	// The submission may carry a whole project
+	ws.SetReadLimit(maxRequestSize)
+	ws.SetReadDeadline(time.Now().Add(h.config.IdleTimeout))
+	var body executeRequest
+	if err := ws.ReadJSON(&body); err != nil {
+		conn.send(sessionMessage{Type: "error", Error: "invalid request body"})
+		return
+	}
+	req, reqErr := newRequest(r, h.languages, &body)
+	switch {
+	case reqErr != nil:
+		conn.send(sessionMessage{Type: "error", Error: reqErr.message})
+		return
+	case body.Stdin != "":
+		conn.send(sessionMessage{Type: "error", Error: "stdin is not supported by interactive sessions"})
+		return
+	case body.CallbackURL != "":
+		conn.send(sessionMessage{Type: "error", Error: "callback_url is only supported by /api/jobs"})
+		return
+	}
+	ws.SetReadLimit(maxMessageSize)
+	ws.SetReadDeadline(time.Time{})
+
+	ctx, cancel := context.WithTimeout(r.Context(), h.config.MaxDuration)
+	defer cancel()
+	session, result, err := h.executor.StartSession(ctx, req)
+	if err != nil {
+		conn.send(sessionMessage{Type: "error", Error: err.Error()})
+		return
+	}
+	if result != nil {
+		conn.send(sessionMessage{Type: "rejected", Result: result})
+		return
+	}
+	defer session.Close()
+	conn.send(sessionMessage{Type: "started"})
+
+	// The first reason to end the session wins. Ending it kills the program,
+	// which ends its output
+	var reasonOnce sync.Once
+	var reason string
+	end := func(why string) {
+		reasonOnce.Do(func() { reason = why })
+		cancel()
+	}
+	idle := time.AfterFunc(h.config.IdleTimeout, func() { end(reasonIdleTimeout) })
+	defer idle.Stop()
+
+	go func() {
+		for {
+			var msg sessionMessage
+			if err := ws.ReadJSON(&msg); err != nil {
+				end(reasonClosed)
+				return
+			}
+			switch msg.Type {
+			case "input":
+				idle.Reset(h.config.IdleTimeout)
+				// Blocks while the program is not reading its input
+				session.Write([]byte(msg.Data))
+			case "resize":
+				if err := session.Resize(msg.Rows, msg.Cols); err != nil {
+					conn.send(sessionMessage{Type: "error", Error: err.Error()})
+				}
+			case "close":
+				end(reasonClosed)
+				return
+			default:
+				conn.send(sessionMessage{Type: "error", Error: "unknown message type: " + msg.Type})
+			}
+		}
+	}()
+
+	buf := make([]byte, sessionReadSize)
+	var partial []byte
+	for {
+		n, err := session.Read(buf)
+		if n > 0 {
+			idle.Reset(h.config.IdleTimeout)
+			complete, rest := splitIncomplete(append(partial, buf[:n]...))
+			partial = append([]byte(nil), rest...)
+			if len(complete) > 0 {
+				conn.send(sessionMessage{Type: "output", Data: string(complete)})
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	if len(partial) > 0 {
+		conn.send(sessionMessage{Type: "output", Data: string(partial)})
+	}
+
+	exitCode, err := session.Wait()
+	if err != nil {
+		exitCode = -1
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		end(reasonMaxDuration)
+	}
+	end(reasonExited)
+	conn.send(sessionMessage{Type: "exit", ExitCode: &exitCode, Reason: reason})
+	conn.closeNormally(reason)
+}
+
+// sessionConn serializes the messages sent over a session's WebSocket.
+type sessionConn struct {
+	mu sync.Mutex
+	ws *websocket.Conn
+}
+
+func (c *sessionConn) send(msg sessionMessage) {
+	data, _ := json.Marshal(msg)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ws.SetWriteDeadline(time.Now().Add(sessionWriteTimeout))
+	// A write error means the client went away, which ends the session
+	c.ws.WriteMessage(websocket.TextMessage, data)
+}
+
+// closeNormally tells the client that the session is over.
+func (c *sessionConn) closeNormally(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	c.ws.WriteControl(websocket.CloseMessage, message, time.Now().Add(sessionWriteTimeout))
+}
+
+func (c *sessionConn) close() {
+	c.ws.Close()
+}
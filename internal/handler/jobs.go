@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/isavita/codeexec/internal/auth"
+	"github.com/isavita/codeexec/internal/jobs"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// JobsHandler serves the asynchronous job API: POST /api/jobs queues an
+// execution, GET /api/jobs/{id} reports its status and result, and
+// DELETE /api/jobs/{id} cancels it. Jobs are only visible to the API key
+// that submitted them.
+type JobsHandler struct {
+	queue     *jobs.Queue
+	languages *language.Registry
+}
+
+func NewJobsHandler(queue *jobs.Queue, languages *language.Registry) *JobsHandler {
+	return &JobsHandler{queue: queue, languages: languages}
+}
+
+// Submit queues the execution described by an execute request body and
+// responds with the queued job. The body may name a callback_url to which
+// the finished job is POSTed.
+func (h *JobsHandler) Submit(w http.ResponseWriter, r *http.Request) {
+	var body executeRequest
+	req, ok := parseRequest(w, r, h.languages, &body)
+	if !ok {
+		return
+	}
+	if body.CallbackURL != "" {
+		if err := h.queue.ValidateCallback(body.CallbackURL); err != nil {
+			errorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	job, err := h.queue.Submit(req, owner(r), body.CallbackURL)
+	if errors.Is(err, jobs.ErrQueueFull) || errors.Is(err, jobs.ErrClosed) {
+		errorResponse(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/api/jobs/"+job.ID)
+	jsonResponse(w, job, http.StatusAccepted)
+}
+
+// Status responds with the job named in the path.
+func (h *JobsHandler) Status(w http.ResponseWriter, r *http.Request) {
+	job, err := h.queue.Get(r.PathValue("id"), owner(r))
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, job, http.StatusOK)
+}
+
+// Cancel cancels the job named in the path. A running job keeps the running
+// status until its execution has been stopped.
+func (h *JobsHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	job, err := h.queue.Cancel(r.PathValue("id"), owner(r))
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		errorResponse(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, jobs.ErrFinished):
+		errorResponse(w, err.Error(), http.StatusConflict)
+	case err != nil:
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
+	default:
+		jsonResponse(w, job, http.StatusAccepted)
+	}
+}
+
+// owner identifies the API key a request was made with. Requests without a
+// configured key share the empty owner.
+func owner(r *http.Request) string {
+	if key, ok := auth.FromContext(r.Context()); ok {
+		return key.Name
+	}
+	return ""
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/isavita/codeexec/internal/auth"
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/judge"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// maxTestCases bounds the test cases of a judge request.
+const maxTestCases = 500
+
+// JudgeHandler serves POST /api/judge, which runs a submission against test
+// cases and responds with a verdict per case.
+type JudgeHandler struct {
+	judge     *judge.Judge
+	languages *language.Registry
+}
+
+func NewJudgeHandler(exec executor.Executor, languages *language.Registry) *JudgeHandler {
+	return &JudgeHandler{judge: judge.New(exec), languages: languages}
+}
+
+// judgeRequest is the body of /api/judge: a submission as for /api/execute,
+// without stdin, and the test cases it is run against. The submission's
+// limits apply to every case unless the case overrides them.
+type judgeRequest struct {
+	executeRequest
+	TestCases  []testCaseRequest  `json:"test_cases"`
+	Comparison *comparisonRequest `json:"comparison"`
+}
+
+type testCaseRequest struct {
+	Name           string          `json:"name"`
+	Stdin          string          `json:"stdin"`
+	ExpectedStdout string          `json:"expected_stdout"`
+	Limits         executor.Limits `json:"limits"`
+}
+
+type comparisonRequest struct {
+	Mode      judge.Mode `json:"mode"`
+	Tolerance float64    `json:"tolerance"`
+	// Checker is the checker program of the checker mode, with the same
+	// fields as a submission.
+	Checker *executeRequest `json:"checker"`
+}
+
+func (h *JudgeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body judgeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&body); err != nil {
+		errorResponse(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	req, reqErr := newRequest(r, h.languages, &body.executeRequest)
+	if reqErr != nil {
+		errorResponse(w, reqErr.message, reqErr.status)
+		return
+	}
+	switch {
+	case body.Stdin != "":
+		errorResponse(w, "stdin is given by the test cases", http.StatusBadRequest)
+		return
+	case body.CallbackURL != "":
+		errorResponse(w, "callback_url is only supported by /api/jobs", http.StatusBadRequest)
+		return
+	case len(body.TestCases) == 0:
+		errorResponse(w, "test_cases not provided", http.StatusBadRequest)
+		return
+	case len(body.TestCases) > maxTestCases:
+		errorResponse(w, fmt.Sprintf("more than %d test cases", maxTestCases), http.StatusBadRequest)
+		return
+	}
+
+	cases, reqErr := h.testCases(r, &body)
+	if reqErr != nil {
+		errorResponse(w, reqErr.message, reqErr.status)
+		return
+	}
+	comparison, reqErr := h.comparison(r, body.Comparison)
+	if reqErr != nil {
+		errorResponse(w, reqErr.message, reqErr.status)
+		return
+	}
+
+	report, err := h.judge.Run(r.Context(), req, cases, comparison)
+	if errors.Is(err, executor.ErrInvalidRequest) {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	jsonResponse(w, report, http.StatusOK)
+}
+
+// testCases resolves the limits of each test case against the language and
+// the request's API key, starting from the submission's limits.
+func (h *JudgeHandler) testCases(r *http.Request, body *judgeRequest) ([]judge.TestCase, *requestError) {
+	// The language was checked along with the submission
+	lang, _ := h.languages.Lookup(body.Language)
+	var ceiling language.Limits
+	if key, ok := auth.FromContext(r.Context()); ok {
+		ceiling = key.MaxLimits
+	}
+
+	cases := make([]judge.TestCase, len(body.TestCases))
+	for i, tc := range body.TestCases {
+		if len(tc.Stdin) > maxStdinSize {
+			return nil, &requestError{http.StatusRequestEntityTooLarge, fmt.Sprintf("test case %d: stdin exceeds %d bytes", i+1, maxStdinSize)}
+		}
+		limits, err := lang.ResolveLimits(requestLimits(body.Limits).Override(requestLimits(tc.Limits)), ceiling)
+		if err != nil {
+			return nil, &requestError{http.StatusBadRequest, fmt.Sprintf("test case %d: %v", i+1, err)}
+		}
+		cases[i] = judge.TestCase{Name: tc.Name, Stdin: tc.Stdin, ExpectedStdout: tc.ExpectedStdout, Limits: limits}
+	}
+	return cases, nil
+}
+
+// comparison validates the comparison of a judge request, which defaults to
+// an exact comparison.
+func (h *JudgeHandler) comparison(r *http.Request, body *comparisonRequest) (judge.Comparison, *requestError) {
+	if body == nil {
+		return judge.Comparison{Mode: judge.Exact}, nil
+	}
+	comparison := judge.Comparison{Mode: body.Mode, Tolerance: body.Tolerance}
+	switch body.Mode {
+	case "":
+		comparison.Mode = judge.Exact
+	case judge.Exact, judge.Whitespace:
+	case judge.Float:
+		if body.Tolerance < 0 {
+			return judge.Comparison{}, &requestError{http.StatusBadRequest, "tolerance must not be negative"}
+		}
+	case judge.Checker:
+		if body.Checker == nil {
+			return judge.Comparison{}, &requestError{http.StatusBadRequest, "checker not provided"}
+		}
+		if body.Checker.Stdin != "" || body.Checker.Network != nil || body.Checker.CallbackURL != "" {
+			return judge.Comparison{}, &requestError{http.StatusBadRequest, "checker: only code, language, files, archive, entrypoint and limits are supported"}
+		}
+		checker, reqErr := newRequest(r, h.languages, body.Checker)
+		if reqErr != nil {
+			return judge.Comparison{}, &requestError{reqErr.status, "checker: " + reqErr.message}
+		}
+		// Checkers have no use for the network, even when the API key grants
+		// it by default
+		checker.Network = nil
+		comparison.Checker = &checker
+	default:
+		return judge.Comparison{}, &requestError{http.StatusBadRequest, "unknown comparison mode: " + string(body.Mode)}
+	}
+	if body.Mode != judge.Checker && body.Checker != nil {
+		return judge.Comparison{}, &requestError{http.StatusBadRequest, "checker is only supported by the checker mode"}
+	}
+	return comparison, nil
+}
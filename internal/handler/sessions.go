@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/language"
+	"github.com/isavita/codeexec/internal/sessions"
+)
+
+// SessionsHandler serves stateful sessions: POST /api/sessions starts a
+// session, POST /api/sessions/{id}/execute evaluates a cell in it,
+// GET /api/sessions/{id} reports it and DELETE /api/sessions/{id} deletes
+// it. Sessions are only visible to the API key that created them.
+type SessionsHandler struct {
+	manager   *sessions.Manager
+	languages *language.Registry
+}
+
+func NewSessionsHandler(manager *sessions.Manager, languages *language.Registry) *SessionsHandler {
+	return &SessionsHandler{manager: manager, languages: languages}
+}
+
+// sessionRequest is the body of POST /api/sessions. The files and archive
+// seed the session's working directory, and the limits apply to each cell.
+type sessionRequest struct {
+	Language string            `json:"language"`
+	Files    map[string]string `json:"files"`
+	Archive  string            `json:"archive"`
+	Limits   executor.Limits   `json:"limits"`
+	Network  *networkRequest   `json:"network"`
+}
+
+// cellRequest is the body of POST /api/sessions/{id}/execute.
+type cellRequest struct {
+	Code string `json:"code"`
+}
+
+// Create starts a session and responds with it.
+func (h *SessionsHandler) Create(w http.ResponseWriter, r *http.Request) {
+	var body sessionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&body); err != nil {
+		errorResponse(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.Language == "" {
+		errorResponse(w, "language not specified", http.StatusBadRequest)
+		return
+	}
+	lang, ok := h.languages.Lookup(body.Language)
+	if !ok {
+		errorResponse(w, "unsupported language: "+body.Language, http.StatusBadRequest)
+		return
+	}
+	if lang.KernelCommand() == nil {
+		errorResponse(w, "sessions are not supported for language: "+body.Language, http.StatusBadRequest)
+		return
+	}
+	req, reqErr := resolveRequest(r, lang, &executeRequest{
+		Language: body.Language,
+		Files:    body.Files,
+		Archive:  body.Archive,
+		Limits:   body.Limits,
+		Network:  body.Network,
+	})
+	if reqErr != nil {
+		errorResponse(w, reqErr.message, reqErr.status)
+		return
+	}
+
+	session, err := h.manager.Create(r.Context(), req, owner(r))
+	switch {
+	case errors.Is(err, sessions.ErrTooManySessions) || errors.Is(err, sessions.ErrClosed):
+		errorResponse(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, executor.ErrInvalidRequest):
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/api/sessions/"+session.ID)
+	jsonResponse(w, session, http.StatusCreated)
+}
+
+// Status responds with the session named in the path.
+func (h *SessionsHandler) Status(w http.ResponseWriter, r *http.Request) {
+	session, err := h.manager.Get(r.PathValue("id"), owner(r))
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	jsonResponse(w, session, http.StatusOK)
+}
+
+// Execute evaluates a cell in the session named in the path and responds
+// with its result. A cell that times out or runs out of memory ends the
+// session.
+func (h *SessionsHandler) Execute(w http.ResponseWriter, r *http.Request) {
+	var body cellRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize)).Decode(&body); err != nil {
+		errorResponse(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if body.Code == "" {
+		errorResponse(w, "code not provided", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.manager.Execute(r.Context(), r.PathValue("id"), owner(r), body.Code)
+	switch {
+	case errors.Is(err, sessions.ErrNotFound):
+		errorResponse(w, err.Error(), http.StatusNotFound)
+	case err != nil:
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
+	default:
+		jsonResponse(w, result, http.StatusOK)
+	}
+}
+
+// Delete deletes the session named in the path.
+func (h *SessionsHandler) Delete(w http.ResponseWriter, r *http.Request) {
+	if err := h.manager.Delete(r.PathValue("id"), owner(r)); err != nil {
+		errorResponse(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// StreamHandler serves POST /api/execute/stream, which takes the same body as
+// /api/execute and responds with Server-Sent Events: "stdout" and "stderr"
+// events carry the program's output as it is written, and a final "result"
+// event carries the execution result, or an "error" event an infrastructure
+// failure.
+type StreamHandler struct {
+	executor  executor.Executor
+	languages *language.Registry
+}
+
+func NewStreamHandler(exec executor.Executor, languages *language.Registry) *StreamHandler {
+	return &StreamHandler{executor: exec, languages: languages}
+}
+
+// outputEvent is the data of stdout and stderr events.
+type outputEvent struct {
+	Data string `json:"data"`
+}
+
+func (h *StreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		errorResponse(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	var body executeRequest
+	req, ok := parseRequest(w, r, h.languages, &body)
+	if !ok {
+		return
+	}
+	if body.CallbackURL != "" {
+		errorResponse(w, "callback_url is only supported by /api/jobs", http.StatusBadRequest)
+		return
+	}
+
+	events := &eventWriter{w: w, flusher: flusher}
+	req.Output = events.output
+
+	result, err := h.executor.Execute(r.Context(), req)
+	events.flushOutput()
+	if err != nil && !events.started {
+		// Nothing was streamed yet, so the error can still be a plain
+		// response
+		status := http.StatusInternalServerError
+		if errors.Is(err, executor.ErrInvalidRequest) {
+			status = http.StatusBadRequest
+		}
+		errorResponse(w, err.Error(), status)
+		return
+	}
+	if err != nil {
+		events.send("error", map[string]string{"error": err.Error()})
+		return
+	}
+	events.send("result", result)
+}
+
+// eventWriter writes Server-Sent Events, sending the response headers with
+// the first event. Output is only sent up to the last complete UTF-8
+// character, the rest being held back until the next chunk, so that
+// characters split across chunks survive JSON encoding.
+type eventWriter struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+
+	mu      sync.Mutex
+	started bool
+	partial map[executor.Stream][]byte
+}
+
+func (e *eventWriter) output(stream executor.Stream, p []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	complete, rest := splitIncomplete(append(e.partial[stream], p...))
+	if e.partial == nil {
+		e.partial = make(map[executor.Stream][]byte)
+	}
+	e.partial[stream] = append([]byte(nil), rest...)
+	if len(complete) > 0 {
+		e.write(string(stream), outputEvent{Data: string(complete)})
+	}
+}
+
+// splitIncomplete splits data before the incomplete UTF-8 character it ends
+// with, if any, which is at most utf8.UTFMax-1 bytes long.
+func splitIncomplete(data []byte) (complete, rest []byte) {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax+1; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return data[:i], data[i:]
+			}
+			break
+		}
+	}
+	return data, nil
+}
+
+// flushOutput sends the output held back, once the program has exited.
+func (e *eventWriter) flushOutput() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, stream := range []executor.Stream{executor.Stdout, executor.Stderr} {
+		if data := e.partial[stream]; len(data) > 0 {
+			e.write(string(stream), outputEvent{Data: string(data)})
+		}
+	}
+	e.partial = nil
+}
+
+func (e *eventWriter) send(event string, data any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.write(event, data)
+}
+
+func (e *eventWriter) write(event string, data any) {
+	if !e.started {
+		e.started = true
+		header := e.w.Header()
+		header.Set("Content-Type", "text/event-stream")
+		header.Set("Cache-Control", "no-cache")
+		// Keep reverse proxies such as nginx from buffering the stream
+		header.Set("X-Accel-Buffering", "no")
+		e.w.WriteHeader(http.StatusOK)
+	}
+	payload, _ := json.Marshal(data)
+	// A write error means the client went away, which cancels the request's
+	// context and so the execution
+	fmt.Fprintf(e.w, "event: %s\ndata: %s\n\n", event, payload)
+	e.flusher.Flush()
+}
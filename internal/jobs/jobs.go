@@ -0,0 +1,372 @@
+// Package jobs runs executions asynchronously: submissions are queued and
+// picked up by a fixed pool of workers, and their status and results are kept
+// for clients to poll.
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/webhook"
+)
+
+const (
+	// defaultWorkers is used when Config leaves Workers unset.
+	defaultWorkers = 4
+	// defaultQueueSize is used when Config leaves QueueSize unset.
+	defaultQueueSize = 100
+	// defaultRetention is used when Config leaves Retention unset.
+	defaultRetention = time.Hour
+)
+
+// Status is the state of a job.
+type Status string
+
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+// Finished reports whether a job in this state will not change anymore.
+func (s Status) Finished() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCancelled
+}
+
+var (
+	// ErrQueueFull is returned by Submit when no more jobs can be queued.
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrNotFound is returned for unknown, expired or foreign jobs.
+	ErrNotFound = errors.New("job not found")
+	// ErrFinished is returned when cancelling a job that already finished.
+	ErrFinished = errors.New("job already finished")
+	// ErrClosed is returned by Submit once the queue is closed.
+	ErrClosed = errors.New("job queue is closed")
+)
+
+// CallbackStatus is the state of a job's callback delivery.
+type CallbackStatus string
+
+const (
+	CallbackPending   CallbackStatus = "pending"
+	CallbackDelivered CallbackStatus = "delivered"
+	CallbackFailed    CallbackStatus = "failed"
+)
+
+// Callback describes the delivery of a finished job to its callback URL.
+type Callback struct {
+	URL    string         `json:"url"`
+	Status CallbackStatus `json:"status"`
+	// Attempts logs every delivery attempt so far.
+	Attempts []webhook.Attempt `json:"attempts,omitempty"`
+}
+
+// Job is a snapshot of a submitted execution. A job succeeds when the
+// execution produced a result, whatever the program's exit code, and fails
+// when the execution itself failed.
+type Job struct {
+	ID     string           `json:"id"`
+	Status Status           `json:"status"`
+	Result *executor.Result `json:"result,omitempty"`
+	// Error describes why a failed job failed.
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	// Callback is set for jobs submitted with a callback URL.
+	Callback *Callback `json:"callback,omitempty"`
+}
+
+// Config configures a Queue.
+type Config struct {
+	// Workers is the number of jobs run concurrently. Defaults to 4.
+	Workers int
+	// QueueSize is the number of jobs that may wait for a worker. Defaults
+	// to 100.
+	QueueSize int
+	// Retention is how long finished jobs are kept. Defaults to an hour.
+	Retention time.Duration
+	// Webhooks configures the delivery of finished jobs to their callback
+	// URLs.
+	Webhooks webhook.Config
+}
+
+// job is the queue's record of a job.
+type job struct {
+	Job
+	req executor.Request
+	// owner is the name of the API key that submitted the job. Only the
+	// same owner can see or cancel it.
+	owner  string
+	cancel context.CancelFunc
+}
+
+// Queue runs jobs on a pool of workers in front of an executor.
+type Queue struct {
+	exec     executor.Executor
+	config   Config
+	webhooks *webhook.Sender
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	pending chan *job
+	closed  bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New starts the workers of a queue.
+func New(exec executor.Executor, config Config) *Queue {
+	if config.Workers <= 0 {
+		config.Workers = defaultWorkers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.Retention <= 0 {
+		config.Retention = defaultRetention
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		exec:     exec,
+		config:   config,
+		webhooks: webhook.New(config.Webhooks),
+		jobs:     make(map[string]*job),
+		pending:  make(chan *job, config.QueueSize),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	for range config.Workers {
+		q.wg.Add(1)
+		go q.work()
+	}
+	q.wg.Add(1)
+	go q.expire()
+	return q
+}
+
+// Submit queues an execution on behalf of owner and returns the queued job.
+// If callbackURL is not empty, the job is POSTed to it once finished.
+func (q *Queue) Submit(req executor.Request, owner, callbackURL string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return Job{}, ErrClosed
+	}
+
+	j := &job{
+		Job:   Job{ID: newID(), Status: StatusQueued, CreatedAt: time.Now()},
+		req:   req,
+		owner: owner,
+	}
+	if callbackURL != "" {
+		j.Callback = &Callback{URL: callbackURL, Status: CallbackPending}
+	}
+	select {
+	case q.pending <- j:
+	default:
+		return Job{}, ErrQueueFull
+	}
+	q.jobs[j.ID] = j
+	return j.snapshot(), nil
+}
+
+// ValidateCallback checks a callback URL against the webhook configuration.
+func (q *Queue) ValidateCallback(rawURL string) error {
+	return q.webhooks.ValidateURL(rawURL)
+}
+
+// Get returns the job with the given ID if it belongs to owner.
+func (q *Queue) Get(id, owner string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok || j.owner != owner {
+		return Job{}, ErrNotFound
+	}
+	return j.snapshot(), nil
+}
+
+// Cancel cancels a queued or running job belonging to owner. A running job
+// is reported as cancelled once its execution has been stopped.
+func (q *Queue) Cancel(id, owner string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok || j.owner != owner {
+		return Job{}, ErrNotFound
+	}
+	switch {
+	case j.Status.Finished():
+		return j.snapshot(), ErrFinished
+	case j.Status == StatusQueued:
+		// The worker dequeuing it skips it
+		q.finish(j, StatusCancelled, nil, "")
+	case j.cancel != nil:
+		j.cancel()
+	}
+	return j.snapshot(), nil
+}
+
+// Close stops accepting jobs, cancels the running ones and pending callback
+// deliveries, and waits for the workers to exit. Jobs still queued are
+// cancelled.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.pending)
+	q.mu.Unlock()
+
+	q.cancel()
+	q.wg.Wait()
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for j := range q.pending {
+		q.run(j)
+	}
+}
+
+func (q *Queue) run(j *job) {
+	q.mu.Lock()
+	if j.Status != StatusQueued {
+		q.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(q.ctx)
+	defer cancel()
+	if ctx.Err() != nil {
+		q.finish(j, StatusCancelled, nil, "")
+		q.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	j.Status = StatusRunning
+	j.StartedAt = &now
+	j.cancel = cancel
+	q.mu.Unlock()
+
+	result, err := q.exec.Execute(ctx, j.req)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	switch {
+	case ctx.Err() != nil:
+		q.finish(j, StatusCancelled, nil, "")
+	case err != nil:
+		q.finish(j, StatusFailed, nil, err.Error())
+	default:
+		q.finish(j, StatusSucceeded, result, "")
+	}
+}
+
+// finish records the outcome of a job, releases its request, which may hold
+// a whole project, and starts delivering it to its callback URL. q.mu must
+// be held.
+func (q *Queue) finish(j *job, status Status, result *executor.Result, message string) {
+	now := time.Now()
+	j.Status = status
+	j.Result = result
+	j.Error = message
+	j.FinishedAt = &now
+	j.req = executor.Request{}
+	j.cancel = nil
+
+	if j.Callback == nil {
+		return
+	}
+	if q.closed {
+		j.Callback.Status = CallbackFailed
+		return
+	}
+	// The payload is the finished job itself, less the delivery log
+	payload := j.snapshot()
+	payload.Callback = nil
+	body, err := json.Marshal(payload)
+	if err != nil {
+		j.Callback.Status = CallbackFailed
+		return
+	}
+	q.wg.Add(1)
+	go q.deliver(j, body)
+}
+
+// deliver POSTs a finished job to its callback URL, logging every attempt.
+func (q *Queue) deliver(j *job, body []byte) {
+	defer q.wg.Done()
+
+	err := q.webhooks.Deliver(q.ctx, j.Callback.URL, body, func(attempt webhook.Attempt) {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		j.Callback.Attempts = append(j.Callback.Attempts, attempt)
+	})
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if err != nil {
+		j.Callback.Status = CallbackFailed
+	} else {
+		j.Callback.Status = CallbackDelivered
+	}
+}
+
+// snapshot copies a job for use outside the queue's lock. q.mu must be held.
+func (j *job) snapshot() Job {
+	job := j.Job
+	if j.Callback != nil {
+		callback := *j.Callback
+		callback.Attempts = append([]webhook.Attempt(nil), callback.Attempts...)
+		job.Callback = &callback
+	}
+	return job
+}
+
+// expire periodically removes the jobs finished longer than the retention
+// period ago.
+func (q *Queue) expire() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(q.config.Retention / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		q.mu.Lock()
+		for id, j := range q.jobs {
+			delivering := j.Callback != nil && j.Callback.Status == CallbackPending
+			if j.FinishedAt != nil && !delivering && time.Since(*j.FinishedAt) > q.config.Retention {
+				delete(q.jobs, id)
+			}
+		}
+		q.mu.Unlock()
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("jobs: failed to generate ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,96 @@
+package judge
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Mode selects how a program's output is compared with the expected output.
+type Mode string
+
+const (
+	// Exact requires the output to match byte for byte.
+	Exact Mode = "exact"
+	// Whitespace compares the whitespace-separated tokens of the output,
+	// ignoring how many spaces, tabs and newlines separate them.
+	Whitespace Mode = "whitespace"
+	// Float compares tokens as Whitespace does, except that tokens that
+	// are both numbers match when they are within the tolerance.
+	Float Mode = "float"
+	// Checker runs a checker program to judge the output.
+	Checker Mode = "checker"
+)
+
+// defaultTolerance is used when a Float comparison leaves Tolerance unset.
+const defaultTolerance = 1e-6
+
+// compare compares output with expected according to mode, which must not
+// be Checker. It returns an empty message when they match, and otherwise
+// describes the first difference.
+func compare(mode Mode, tolerance float64, expected, output string) string {
+	switch mode {
+	case Whitespace:
+		return compareTokens(expected, output, func(e, o string) bool { return e == o })
+	case Float:
+		if tolerance <= 0 {
+			tolerance = defaultTolerance
+		}
+		return compareTokens(expected, output, func(e, o string) bool { return floatsMatch(e, o, tolerance) })
+	default:
+		return compareExact(expected, output)
+	}
+}
+
+func compareExact(expected, output string) string {
+	if expected == output {
+		return ""
+	}
+	expectedLines := strings.SplitAfter(expected, "\n")
+	outputLines := strings.SplitAfter(output, "\n")
+	for i := 0; i < len(expectedLines) && i < len(outputLines); i++ {
+		if expectedLines[i] != outputLines[i] {
+			return fmt.Sprintf("line %d: expected %q, got %q", i+1, expectedLines[i], outputLines[i])
+		}
+	}
+	if len(expectedLines) > len(outputLines) {
+		return fmt.Sprintf("output ends before line %d", len(outputLines)+1)
+	}
+	return fmt.Sprintf("unexpected output after line %d", len(expectedLines))
+}
+
+func compareTokens(expected, output string, match func(expected, output string) bool) string {
+	expectedTokens := strings.Fields(expected)
+	outputTokens := strings.Fields(output)
+	for i := 0; i < len(expectedTokens) && i < len(outputTokens); i++ {
+		if !match(expectedTokens[i], outputTokens[i]) {
+			return fmt.Sprintf("token %d: expected %q, got %q", i+1, expectedTokens[i], outputTokens[i])
+		}
+	}
+	switch {
+	case len(expectedTokens) > len(outputTokens):
+		return fmt.Sprintf("expected %d tokens, got %d", len(expectedTokens), len(outputTokens))
+	case len(expectedTokens) < len(outputTokens):
+		return fmt.Sprintf("unexpected token %d: %q", len(expectedTokens)+1, outputTokens[len(expectedTokens)])
+	}
+	return ""
+}
+
+// floatsMatch reports whether two tokens are equal, or are numbers whose
+// absolute or relative difference is within tolerance.
+func floatsMatch(expected, output string, tolerance float64) bool {
+	if expected == output {
+		return true
+	}
+	e, err := strconv.ParseFloat(expected, 64)
+	if err != nil {
+		return false
+	}
+	o, err := strconv.ParseFloat(output, 64)
+	if err != nil || math.IsNaN(o) {
+		return false
+	}
+	diff := math.Abs(e - o)
+	return diff <= tolerance || diff <= tolerance*math.Abs(e)
+}
@@ -0,0 +1,231 @@
+// Package judge grades submissions against test cases: the program runs once
+// per test case with the case's input, and its output is compared with the
+// expected output to give the case a verdict.
+package judge
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// Verdict is the outcome of a test case.
+type Verdict string
+
+const (
+	Accepted            Verdict = "accepted"
+	WrongAnswer         Verdict = "wrong_answer"
+	RuntimeError        Verdict = "runtime_error"
+	TimeLimitExceeded   Verdict = "time_limit_exceeded"
+	MemoryLimitExceeded Verdict = "memory_limit_exceeded"
+	CompileError        Verdict = "compile_error"
+	// CheckerError is given when the checker program failed to judge the
+	// output, so that the output's correctness is unknown.
+	CheckerError Verdict = "checker_error"
+)
+
+// The files a checker program finds in its working directory.
+const (
+	InputFile    = "input.txt"
+	ExpectedFile = "expected.txt"
+	OutputFile   = "output.txt"
+)
+
+// TestCase is an input of the program and the output expected for it.
+type TestCase struct {
+	// Name identifies the case in the report.
+	Name           string
+	Stdin          string
+	ExpectedStdout string
+	// Limits are the limits of the case's run. Zero fields select the
+	// submission's limits.
+	Limits language.Limits
+}
+
+// Comparison describes how outputs are compared with expected outputs.
+type Comparison struct {
+	// Mode defaults to Exact.
+	Mode Mode
+	// Tolerance is the largest absolute or relative difference between
+	// numbers in the Float mode. Defaults to 1e-6.
+	Tolerance float64
+	// Checker is the program judging outputs in the Checker mode. It runs
+	// with the case's input, its expected output and the program's output in
+	// InputFile, ExpectedFile and OutputFile, and accepts the output by
+	// exiting with 0 or rejects it by exiting with 1. Its stdout explains
+	// its verdict.
+	Checker *executor.Request
+}
+
+// CaseResult is the outcome of a test case.
+type CaseResult struct {
+	Name    string  `json:"name,omitempty"`
+	Verdict Verdict `json:"verdict"`
+	// Message explains the verdict, for instance by describing the first
+	// difference from the expected output, or is the checker's comment.
+	Message  string `json:"message,omitempty"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+	// WallTimeMs is the run time of the program, without setting up its
+	// sandbox. CPUTimeMs is its CPU time in user and kernel mode.
+	WallTimeMs      int64 `json:"wall_time_ms"`
+	CPUTimeMs       int64 `json:"cpu_time_ms"`
+	PeakMemoryBytes int64 `json:"peak_memory_bytes"`
+	// Limits are the limits the case ran with.
+	Limits executor.Limits `json:"limits"`
+}
+
+// Report is the outcome of judging a submission.
+type Report struct {
+	// Verdict is that of the first case not accepted, or Accepted when
+	// every case is.
+	Verdict Verdict `json:"verdict"`
+	Passed  int     `json:"passed"`
+	Total   int     `json:"total"`
+	// SyntaxCheck and Compile report the checks of the submission, as in an
+	// execution result.
+	SyntaxCheck executor.SyntaxCheck    `json:"syntax_check"`
+	Compile     *executor.CompileResult `json:"compile,omitempty"`
+	Cases       []CaseResult            `json:"cases"`
+	// WallTimeMs is the time taken to judge the whole submission.
+	WallTimeMs int64 `json:"wall_time_ms"`
+}
+
+// Judge runs submissions against test cases on an executor.
+type Judge struct {
+	exec executor.Executor
+}
+
+func New(exec executor.Executor) *Judge {
+	return &Judge{exec: exec}
+}
+
+// Run judges the submission in req against the test cases, one after the
+// other. When the executor is an executor.BatchExecutor, every case runs in
+// the same sandbox, so that the submission is set up and compiled once. A
+// submission rejected by its syntax check or compiler fails every case with
+// CompileError. Errors are infrastructure errors, or invalid requests
+// wrapping executor.ErrInvalidRequest.
+func (j *Judge) Run(ctx context.Context, req executor.Request, cases []TestCase, comparison Comparison) (*Report, error) {
+	start := time.Now()
+	runs := make([]executor.Run, len(cases))
+	for i, tc := range cases {
+		runs[i] = executor.Run{Stdin: tc.Stdin, Limits: tc.Limits}
+	}
+	var batch *executor.BatchResult
+	var err error
+	if batchExec, ok := j.exec.(executor.BatchExecutor); ok {
+		batch, err = batchExec.ExecuteBatch(ctx, req, runs)
+	} else {
+		batch, err = executor.ExecuteEach(ctx, j.exec, req, runs)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	report := &Report{
+		Verdict:     Accepted,
+		Total:       len(cases),
+		SyntaxCheck: batch.SyntaxCheck,
+		Compile:     batch.Compile,
+		Cases:       make([]CaseResult, len(cases)),
+	}
+	for i, tc := range cases {
+		if !batch.SyntaxCheck.Passed {
+			// Every case fails the same way
+			report.Cases[i] = CaseResult{Name: tc.Name, Verdict: CompileError, ExitCode: -1}
+			continue
+		}
+		caseResult, err := j.judge(ctx, tc, batch.Runs[i], comparison)
+		if err != nil {
+			return nil, fmt.Errorf("test case %d: %w", i+1, err)
+		}
+		report.Cases[i] = caseResult
+	}
+
+	for _, c := range report.Cases {
+		if c.Verdict == Accepted {
+			report.Passed++
+		} else if report.Verdict == Accepted {
+			report.Verdict = c.Verdict
+		}
+	}
+	report.WallTimeMs = time.Since(start).Milliseconds()
+	return report, nil
+}
+
+// judge gives a verdict on the result of a test case's run.
+func (j *Judge) judge(ctx context.Context, tc TestCase, result *executor.Result, comparison Comparison) (CaseResult, error) {
+	c := CaseResult{
+		Name:            tc.Name,
+		Stdout:          result.Stdout,
+		Stderr:          result.Stderr,
+		ExitCode:        result.ExitCode,
+		WallTimeMs:      result.Timings.RunMs,
+		CPUTimeMs:       result.CPUUserMs + result.CPUSystemMs,
+		PeakMemoryBytes: result.PeakMemoryBytes,
+		Limits:          result.Limits,
+	}
+	switch {
+	case result.TimedOut:
+		c.Verdict = TimeLimitExceeded
+	case result.MemoryExceeded:
+		c.Verdict = MemoryLimitExceeded
+	case result.ExitCode != 0:
+		c.Verdict = RuntimeError
+		c.Message = fmt.Sprintf("exit code %d", result.ExitCode)
+	case result.StdoutTruncated:
+		c.Verdict = WrongAnswer
+		c.Message = "output exceeds the output limit"
+	case comparison.Mode == Checker:
+		verdict, message, err := j.check(ctx, tc, result.Stdout, comparison.Checker)
+		if err != nil {
+			return CaseResult{}, err
+		}
+		c.Verdict = verdict
+		c.Message = message
+	default:
+		c.Verdict = Accepted
+		if c.Message = compare(comparison.Mode, comparison.Tolerance, tc.ExpectedStdout, result.Stdout); c.Message != "" {
+			c.Verdict = WrongAnswer
+		}
+	}
+	return c, nil
+}
+
+// check runs the checker program on the output of a test case.
+func (j *Judge) check(ctx context.Context, tc TestCase, output string, checker *executor.Request) (Verdict, string, error) {
+	req := *checker
+	req.Files = make(map[string]string, len(checker.Files)+3)
+	for name, content := range checker.Files {
+		req.Files[name] = content
+	}
+	req.Files[InputFile] = tc.Stdin
+	req.Files[ExpectedFile] = tc.ExpectedStdout
+	req.Files[OutputFile] = output
+
+	result, err := j.exec.Execute(ctx, req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to run checker: %w", err)
+	}
+	message := strings.TrimSpace(result.Stdout)
+	switch {
+	case !result.SyntaxCheck.Passed:
+		return CheckerError, "checker rejected: " + strings.TrimSpace(result.SyntaxCheck.Output), nil
+	case result.TimedOut:
+		return CheckerError, "checker timed out", nil
+	case result.ExitCode == 0:
+		return Accepted, message, nil
+	case result.ExitCode == 1:
+		return WrongAnswer, message, nil
+	}
+	if message == "" {
+		message = strings.TrimSpace(result.Stderr)
+	}
+	return CheckerError, fmt.Sprintf("checker exited with %d: %s", result.ExitCode, message), nil
+}
@@ -0,0 +1,88 @@
+// Node.js kernel of stateful sessions. Each line read from stdin is a cell,
+// {"code": ..., "output_bytes": ..., "nonce": ...}, evaluated in the global
+// context kept across cells; a JSON reply carrying the cell's nonce is
+// written to stdout for each. The cell's output is captured at the file
+// descriptor level, so that the output of child processes is captured too,
+// and never mixes with the replies.
+const fs = require('fs');
+const os = require('os');
+const path = require('path');
+const readline = require('readline');
+const util = require('util');
+const vm = require('vm');
+
+// Node cannot duplicate file descriptors, so stdin and stdout are reopened
+// through /proc for the requests and replies, and the standard descriptors
+// are closed and take the lowest ones opened next: /dev/null for stdin, and
+// files capturing each cell's output for stdout and stderr
+const requests = fs.openSync('/proc/self/fd/0', 'r');
+const replies = fs.openSync('/proc/self/fd/1', 'w');
+const captures = fs.mkdtempSync(path.join(os.tmpdir(), 'kernel-'));
+for (const [fd, file, flags] of [[0, os.devNull, 'r'], [1, path.join(captures, 'stdout'), 'a+'], [2, path.join(captures, 'stderr'), 'a+']]) {
+  fs.closeSync(fd);
+  if (fs.openSync(file, flags) !== fd) {
+    throw new Error(`failed to reopen file descriptor ${fd}`);
+  }
+}
+fs.rmSync(captures, { recursive: true });
+
+// Errors thrown outside of a cell, such as in timers, would otherwise end
+// the kernel
+const report = (err) => process.stderr.write(stack(err));
+process.on('uncaughtException', report);
+process.on('unhandledRejection', report);
+
+globalThis.require = require;
+
+// stack returns an error's stack trace without the kernel's own frames.
+function stack(err) {
+  if (!(err instanceof Error) || !err.stack) {
+    return 'Uncaught ' + util.inspect(err) + '\n';
+  }
+  return err.stack.split('\n').filter((line) => !/^\s+at .*(\[eval\]|node:)/.test(line)).join('\n') + '\n';
+}
+
+function describe(err) {
+  return err instanceof Error ? `${err.name}: ${err.message}` : 'Uncaught ' + util.inspect(err);
+}
+
+// collect returns the first limit bytes a cell wrote to fd, and the number
+// of bytes it wrote.
+function collect(fd, limit) {
+  const size = fs.fstatSync(fd).size;
+  const data = Buffer.alloc(Math.min(size, limit));
+  const n = fs.readSync(fd, data, 0, data.length, 0);
+  return [data.subarray(0, n).toString('utf8'), size];
+}
+
+(async () => {
+  let cell = 0;
+  const lines = readline.createInterface({ input: fs.createReadStream(null, { fd: requests }), crlfDelay: Infinity });
+  for await (const line of lines) {
+    const request = JSON.parse(line);
+    cell++;
+    const limit = request.output_bytes;
+    // Drops the output written since the previous cell
+    fs.ftruncateSync(1, 0);
+    fs.ftruncateSync(2, 0);
+
+    const reply = { nonce: request.nonce };
+    try {
+      let value = vm.runInThisContext(request.code, { filename: `<cell ${cell}>` });
+      if (value instanceof Promise) {
+        value = await value;
+      }
+      if (value !== undefined) {
+        globalThis._ = value;
+        reply.value = util.inspect(value).slice(0, limit);
+      }
+    } catch (err) {
+      process.stderr.write(stack(err));
+      reply.error = describe(err).slice(0, limit);
+    }
+
+    [reply.stdout, reply.stdout_bytes] = collect(1, limit);
+    [reply.stderr, reply.stderr_bytes] = collect(2, limit);
+    fs.writeSync(replies, JSON.stringify(reply) + '\n');
+  }
+})();
@@ -0,0 +1,80 @@
+# Python kernel of stateful sessions. Each line read from stdin is a cell,
+# {"code": ..., "output_bytes": ..., "nonce": ...}, evaluated in a namespace
+# kept across cells; a JSON reply carrying the cell's nonce is written to
+# stdout for each. The cell's output is captured at the file descriptor
+# level, so that the output of subprocesses is captured too, and never mixes
+# with the replies.
+import ast
+import json
+import linecache
+import os
+import sys
+import tempfile
+import traceback
+
+replies = os.fdopen(os.dup(1), 'w', encoding='utf-8')
+requests = os.fdopen(os.dup(0), 'r', encoding='utf-8')
+null = os.open(os.devnull, os.O_RDWR)
+for fd in (0, 1, 2):
+    os.dup2(null, fd)
+
+namespace = {'__name__': '__main__', '__builtins__': __builtins__}
+
+
+def run(name, source):
+    """Runs a cell, returning the repr of its last expression, if any."""
+    tree = ast.parse(source, name, 'exec')
+    last = None
+    if tree.body and isinstance(tree.body[-1], ast.Expr):
+        last = ast.Expression(tree.body.pop().value)
+    exec(compile(tree, name, 'exec'), namespace)
+    if last is None:
+        return None
+    value = eval(compile(last, name, 'eval'), namespace)
+    if value is None:
+        return None
+    namespace['_'] = value
+    return repr(value)
+
+
+def collect(f, limit):
+    f.seek(0)
+    data = f.read(limit)
+    return data.decode('utf-8', 'replace'), os.fstat(f.fileno()).st_size
+
+
+cell = 0
+for line in requests:
+    request = json.loads(line)
+    cell += 1
+    name = f'<cell {cell}>'
+    source = request['code']
+    limit = request['output_bytes']
+    # Lets tracebacks quote the cell's lines
+    linecache.cache[name] = (len(source), None, source.splitlines(True), name)
+
+    reply = {'nonce': request['nonce']}
+    with tempfile.TemporaryFile() as out, tempfile.TemporaryFile() as err:
+        os.dup2(out.fileno(), 1)
+        os.dup2(err.fileno(), 2)
+        try:
+            value = run(name, source)
+            if value is not None:
+                reply['value'] = value[:limit]
+        except BaseException as e:
+            tb = e.__traceback__
+            # Start at the cell's own frames, leaving out the kernel's and
+            # those of the parser when the cell does not parse
+            while tb is not None and not tb.tb_frame.f_code.co_filename.startswith('<cell '):
+                tb = tb.tb_next
+            traceback.print_exception(type(e), e, tb)
+            reply['error'] = traceback.format_exception_only(type(e), e)[-1].strip()[:limit]
+        finally:
+            sys.stdout.flush()
+            sys.stderr.flush()
+            os.dup2(null, 1)
+            os.dup2(null, 2)
+        reply['stdout'], reply['stdout_bytes'] = collect(out, limit)
+        reply['stderr'], reply['stderr_bytes'] = collect(err, limit)
+    replies.write(json.dumps(reply) + '\n')
+    replies.flush()
@@ -0,0 +1,480 @@
+// Package language holds the registry of languages the service can execute.
+// Each language is described declaratively (image, commands, limits) so that
+// adding one is a configuration change rather than a code change.
+package language
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed languages.json
+var defaultConfig []byte
+
+// The kernels of stateful sessions, which evaluate code cell by cell.
+var (
+	//go:embed kernels/python.py
+	pythonKernel string
+	//go:embed kernels/node.js
+	nodeKernel string
+)
+
+// Language describes how to check and run code written in one language.
+type Language struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	// Image is the Docker image the code runs in.
+	Image string `json:"image"`
+	// FileName is the name the submitted code is written to inside the
+	// container's working directory, unless the submission names another
+	// entrypoint.
+	FileName string `json:"file_name"`
+	// Run is the command that executes the code. In this and the other
+	// commands, {entrypoint} is replaced by the entrypoint's path relative to
+	// the working directory.
+	Run []string `json:"run"`
+	// SyntaxCheck is an optional command that exits non-zero when the code
+	// does not parse. It runs in the same image before Run.
+	SyntaxCheck []string `json:"syntax_check,omitempty"`
+	// Compile is an optional command that builds the code into an artifact
+	// in the working directory, which Run then executes. Compiled languages
+	// use it in place of SyntaxCheck.
+	Compile []string `json:"compile,omitempty"`
+	// DiagnosticPattern is a regular expression extracting diagnostics from
+	// the output of SyntaxCheck or Compile. It must capture the named groups
+	// "line" and "message", and may capture "file" and either "column" or
+	// "caret" (the indentation in front of a ^ marker). Defaults to the
+	// "file:line:column: message" format used by most compilers.
+	DiagnosticPattern string `json:"diagnostic_pattern,omitempty"`
+	// OOMPattern is a regular expression matching the error the language's
+	// runtime prints to stderr when an allocation fails, such as Python's
+	// MemoryError, so that such failures are reported as memory failures.
+	OOMPattern string `json:"oom_pattern,omitempty"`
+	// Limits are the default limits of SyntaxCheck and Run.
+	Limits Limits `json:"limits"`
+	// MaxLimits are the largest limits a request may ask for. Fields left
+	// unset default to the corresponding field of Limits.
+	MaxLimits Limits `json:"max_limits,omitempty"`
+	// CompileLimits are the limits of the Compile command. Required when
+	// Compile is set.
+	CompileLimits Limits `json:"compile_limits,omitempty"`
+	// Env are environment variables set for every command, for instance to
+	// move caches to /tmp since the root filesystem is read-only.
+	Env []string `json:"env,omitempty"`
+	// Runtime is the OCI runtime of the language's sandboxes, such as runsc
+	// for gVisor. Defaults to the executor's runtime.
+	Runtime string `json:"runtime,omitempty"`
+	// Security is the sandbox's security profile. Fields left unset take
+	// the hardened defaults.
+	Security Security `json:"security"`
+	// Kernel names the bundled kernel running the language's stateful
+	// sessions: "python" or "node". Languages without one do not support
+	// sessions.
+	Kernel string `json:"kernel,omitempty"`
+
+	diagnostics *regexp.Regexp
+	oom         *regexp.Regexp
+}
+
+// defaultDiagnosticPattern matches "file:line:column: message" lines.
+const defaultDiagnosticPattern = `(?m)^(?P<file>[^:\n]+):(?P<line>\d+):(?P<column>\d+): (?P<message>.*)$`
+
+// Diagnostics returns the compiled DiagnosticPattern.
+func (l *Language) Diagnostics() *regexp.Regexp {
+	return l.diagnostics
+}
+
+// OutOfMemory returns the compiled OOMPattern, or nil if none is set.
+func (l *Language) OutOfMemory() *regexp.Regexp {
+	return l.oom
+}
+
+const (
+	// defaultPids is the process limit of languages that do not set one.
+	defaultPids = 64
+	// defaultOutputBytes is the per-stream output limit of languages that do
+	// not set one.
+	defaultOutputBytes = 1 << 20
+)
+
+// Limits are the resource limits of a phase of an execution.
+type Limits struct {
+	Timeout  Duration `json:"timeout"`
+	MemoryMB int64    `json:"memory_mb"`
+	CPUs     float64  `json:"cpus"`
+	// Pids bounds the number of processes and threads. Defaults to 64.
+	Pids int64 `json:"pids,omitempty"`
+	// OutputBytes bounds each of stdout and stderr. Defaults to 1 MiB.
+	OutputBytes int64 `json:"output_bytes,omitempty"`
+}
+
+// Override returns l with the non-zero fields of o in place of its own.
+func (l Limits) Override(o Limits) Limits {
+	if o.Timeout != 0 {
+		l.Timeout = o.Timeout
+	}
+	if o.MemoryMB != 0 {
+		l.MemoryMB = o.MemoryMB
+	}
+	if o.CPUs != 0 {
+		l.CPUs = o.CPUs
+	}
+	if o.Pids != 0 {
+		l.Pids = o.Pids
+	}
+	if o.OutputBytes != 0 {
+		l.OutputBytes = o.OutputBytes
+	}
+	return l
+}
+
+// ResolveLimits returns the language's default limits overridden by the
+// non-zero fields of requested. It fails when a requested limit is negative
+// or exceeds the language's maximum or the corresponding non-zero field of
+// ceiling, which lets the caller impose tighter maxima of its own.
+func (l *Language) ResolveLimits(requested, ceiling Limits) (Limits, error) {
+	if requested.Timeout < 0 || requested.MemoryMB < 0 || requested.CPUs < 0 || requested.Pids < 0 || requested.OutputBytes < 0 {
+		return Limits{}, errors.New("limits must not be negative")
+	}
+	maximum := l.MaxLimits
+	if ceiling.Timeout > 0 && ceiling.Timeout < maximum.Timeout {
+		maximum.Timeout = ceiling.Timeout
+	}
+	if ceiling.MemoryMB > 0 && ceiling.MemoryMB < maximum.MemoryMB {
+		maximum.MemoryMB = ceiling.MemoryMB
+	}
+	if ceiling.CPUs > 0 && ceiling.CPUs < maximum.CPUs {
+		maximum.CPUs = ceiling.CPUs
+	}
+	if ceiling.Pids > 0 && ceiling.Pids < maximum.Pids {
+		maximum.Pids = ceiling.Pids
+	}
+	if ceiling.OutputBytes > 0 && ceiling.OutputBytes < maximum.OutputBytes {
+		maximum.OutputBytes = ceiling.OutputBytes
+	}
+
+	// Only requested limits are checked, so that a tight ceiling does not
+	// reject requests relying on the defaults
+	switch {
+	case requested.Timeout > maximum.Timeout:
+		return Limits{}, fmt.Errorf("timeout exceeds the maximum of %s", time.Duration(maximum.Timeout))
+	case requested.MemoryMB > maximum.MemoryMB:
+		return Limits{}, fmt.Errorf("memory_mb exceeds the maximum of %d", maximum.MemoryMB)
+	case requested.CPUs > maximum.CPUs:
+		return Limits{}, fmt.Errorf("cpus exceeds the maximum of %g", maximum.CPUs)
+	case requested.Pids > maximum.Pids:
+		return Limits{}, fmt.Errorf("pids exceeds the maximum of %d", maximum.Pids)
+	case requested.OutputBytes > maximum.OutputBytes:
+		return Limits{}, fmt.Errorf("output_bytes exceeds the maximum of %d", maximum.OutputBytes)
+	}
+	return l.Limits.Override(requested), nil
+}
+
+const (
+	// SeccompBundled selects the seccomp profile bundled with the executor.
+	SeccompBundled = "bundled"
+	// SeccompDocker selects the Docker daemon's default seccomp profile.
+	SeccompDocker = "docker"
+	// SeccompUnconfined disables seccomp filtering.
+	SeccompUnconfined = "unconfined"
+)
+
+// Security is the security profile of a language's sandboxes. Every
+// sandbox drops all capabilities but those in CapAdd and has its working
+// directory and /tmp on size-limited tmpfs mounts.
+type Security struct {
+	// User is the user:group commands run as. Defaults to 65534:65534
+	// (nobody).
+	User string `json:"user,omitempty"`
+	// CapAdd are the capabilities kept, such as "NET_BIND_SERVICE".
+	CapAdd []string `json:"cap_add,omitempty"`
+	// AllowNewPrivileges lets setuid binaries gain privileges, which the
+	// no-new-privileges option prevents by default.
+	AllowNewPrivileges bool `json:"allow_new_privileges,omitempty"`
+	// WritableRoot leaves the root filesystem writable. It is read-only by
+	// default.
+	WritableRoot bool `json:"writable_root,omitempty"`
+	// WorkDirMB is the size of the working directory's tmpfs. Defaults to
+	// 64.
+	WorkDirMB int64 `json:"work_dir_mb,omitempty"`
+	// TmpMB is the size of the tmpfs mounted at /tmp. Defaults to 64.
+	TmpMB int64 `json:"tmp_mb,omitempty"`
+	// Seccomp is the seccomp profile: "bundled" (the default), "docker" or
+	// "unconfined".
+	Seccomp string `json:"seccomp,omitempty"`
+	// Ulimits maps resource names such as "nofile" to the soft and hard
+	// limit of every command. They are added to the defaults of 1024 open
+	// files, no core dumps and files no larger than the larger tmpfs.
+	Ulimits map[string]int64 `json:"ulimits,omitempty"`
+}
+
+// ulimitNames are the resources Security.Ulimits may limit.
+var ulimitNames = map[string]bool{
+	"core": true, "cpu": true, "data": true, "fsize": true, "locks": true,
+	"memlock": true, "msgqueue": true, "nice": true, "nofile": true, "nproc": true,
+	"rss": true, "rtprio": true, "rttime": true, "sigpending": true, "stack": true,
+}
+
+func (s *Security) setDefaults() {
+	if s.User == "" {
+		s.User = "65534:65534"
+	}
+	if s.WorkDirMB == 0 {
+		s.WorkDirMB = 64
+	}
+	if s.TmpMB == 0 {
+		s.TmpMB = 64
+	}
+	if s.Seccomp == "" {
+		s.Seccomp = SeccompBundled
+	}
+	ulimits := map[string]int64{
+		"nofile": 1024,
+		"core":   0,
+		"fsize":  max(s.WorkDirMB, s.TmpMB) << 20,
+	}
+	for name, value := range s.Ulimits {
+		ulimits[name] = value
+	}
+	s.Ulimits = ulimits
+}
+
+func (s Security) validate() error {
+	switch {
+	case s.WorkDirMB < 0 || s.TmpMB < 0:
+		return errors.New("security: tmpfs sizes must not be negative")
+	case s.Seccomp != SeccompBundled && s.Seccomp != SeccompDocker && s.Seccomp != SeccompUnconfined:
+		return fmt.Errorf("security: unknown seccomp profile %q", s.Seccomp)
+	}
+	for name, value := range s.Ulimits {
+		if !ulimitNames[name] {
+			return fmt.Errorf("security: unknown ulimit %q", name)
+		}
+		if value < 0 {
+			return fmt.Errorf("security: ulimit %s must not be negative", name)
+		}
+	}
+	for _, capability := range s.CapAdd {
+		if capability == "" || capability == "ALL" {
+			return fmt.Errorf("security: invalid capability %q", capability)
+		}
+	}
+	return nil
+}
+
+// Duration is a time.Duration written as a Go duration string ("5s") in
+// configuration files.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"5s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Registry is a read-only set of languages keyed by name.
+type Registry struct {
+	languages map[string]*Language
+}
+
+// KernelCommand returns the command starting the language's session
+// kernel, or nil if it has none.
+func (l *Language) KernelCommand() []string {
+	switch l.Kernel {
+	case "python":
+		return []string{"python", "-u", "-c", pythonKernel}
+	case "node":
+		return []string{"node", "-e", nodeKernel}
+	}
+	return nil
+}
+
+type config struct {
+	Languages []*Language `json:"languages"`
+}
+
+// Default returns the registry bundled with the binary.
+func Default() *Registry {
+	registry, err := Parse(defaultConfig)
+	if err != nil {
+		panic(fmt.Sprintf("invalid bundled language config: %v", err))
+	}
+	return registry
+}
+
+// Load reads a registry from a JSON config file.
+func Load(filename string) (*Registry, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read language config: %w", err)
+	}
+	registry, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid language config %s: %w", filename, err)
+	}
+	return registry, nil
+}
+
+// Parse builds a registry from the JSON representation of a config file.
+func Parse(data []byte) (*Registry, error) {
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Languages) == 0 {
+		return nil, errors.New("no languages configured")
+	}
+
+	registry := &Registry{languages: make(map[string]*Language, len(cfg.Languages))}
+	for _, lang := range cfg.Languages {
+		if err := lang.validate(); err != nil {
+			return nil, err
+		}
+		if _, ok := registry.languages[lang.Name]; ok {
+			return nil, fmt.Errorf("language %q configured more than once", lang.Name)
+		}
+		registry.languages[lang.Name] = lang
+	}
+	return registry, nil
+}
+
+// Lookup returns the language with the given name.
+func (r *Registry) Lookup(name string) (*Language, bool) {
+	lang, ok := r.languages[name]
+	return lang, ok
+}
+
+// Names returns the names of all configured languages in sorted order.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.languages))
+	for name := range r.languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (l *Language) validate() error {
+	switch {
+	case l.Name == "":
+		return errors.New("language without a name")
+	case l.Image == "":
+		return fmt.Errorf("language %q: image not set", l.Name)
+	case l.FileName == "" || path.Base(l.FileName) != l.FileName || l.FileName == "." || l.FileName == "..":
+		return fmt.Errorf("language %q: file_name must be a plain file name", l.Name)
+	case len(l.Run) == 0:
+		return fmt.Errorf("language %q: run command not set", l.Name)
+	case l.Kernel != "" && l.KernelCommand() == nil:
+		return fmt.Errorf("language %q: unknown kernel %q", l.Name, l.Kernel)
+	}
+	l.Limits.setDefaults()
+	if err := l.Limits.validate(); err != nil {
+		return fmt.Errorf("language %q: %w", l.Name, err)
+	}
+	l.MaxLimits = l.Limits.Override(l.MaxLimits)
+	if err := l.MaxLimits.validate(); err != nil {
+		return fmt.Errorf("language %q: max_%w", l.Name, err)
+	}
+	if err := l.MaxLimits.covers(l.Limits); err != nil {
+		return fmt.Errorf("language %q: %w", l.Name, err)
+	}
+	if len(l.Compile) > 0 {
+		if len(l.SyntaxCheck) > 0 {
+			return fmt.Errorf("language %q: compile and syntax_check are mutually exclusive", l.Name)
+		}
+		l.CompileLimits.setDefaults()
+		if err := l.CompileLimits.validate(); err != nil {
+			return fmt.Errorf("language %q: compile_%w", l.Name, err)
+		}
+	}
+
+	if err := validateEnv(l.Env); err != nil {
+		return fmt.Errorf("language %q: %w", l.Name, err)
+	}
+	l.Security.setDefaults()
+	if err := l.Security.validate(); err != nil {
+		return fmt.Errorf("language %q: %w", l.Name, err)
+	}
+
+	pattern := l.DiagnosticPattern
+	if pattern == "" {
+		pattern = defaultDiagnosticPattern
+	}
+	diagnostics, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("language %q: invalid diagnostic_pattern: %w", l.Name, err)
+	}
+	if diagnostics.SubexpIndex("line") < 0 || diagnostics.SubexpIndex("message") < 0 {
+		return fmt.Errorf("language %q: diagnostic_pattern must capture line and message", l.Name)
+	}
+	l.diagnostics = diagnostics
+
+	if l.OOMPattern != "" {
+		l.oom, err = regexp.Compile(l.OOMPattern)
+		if err != nil {
+			return fmt.Errorf("language %q: invalid oom_pattern: %w", l.Name, err)
+		}
+	}
+	return nil
+}
+
+func (l Limits) validate() error {
+	switch {
+	case l.Timeout <= 0:
+		return errors.New("limits.timeout must be positive")
+	case l.MemoryMB <= 0:
+		return errors.New("limits.memory_mb must be positive")
+	case l.CPUs <= 0:
+		return errors.New("limits.cpus must be positive")
+	case l.Pids <= 0:
+		return errors.New("limits.pids must be positive")
+	case l.OutputBytes <= 0:
+		return errors.New("limits.output_bytes must be positive")
+	}
+	return nil
+}
+
+func validateEnv(env []string) error {
+	for _, variable := range env {
+		if name, _, ok := strings.Cut(variable, "="); !ok || name == "" {
+			return fmt.Errorf("env: expected NAME=value, got %q", variable)
+		}
+	}
+	return nil
+}
+
+func (l *Limits) setDefaults() {
+	if l.Pids == 0 {
+		l.Pids = defaultPids
+	}
+	if l.OutputBytes == 0 {
+		l.OutputBytes = defaultOutputBytes
+	}
+}
+
+// covers reports an error when a field of defaults exceeds the maximum l.
+func (l Limits) covers(defaults Limits) error {
+	if defaults.Timeout > l.Timeout || defaults.MemoryMB > l.MemoryMB || defaults.CPUs > l.CPUs || defaults.Pids > l.Pids || defaults.OutputBytes > l.OutputBytes {
+		return errors.New("max_limits must not be below limits")
+	}
+	return nil
+}
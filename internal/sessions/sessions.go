@@ -0,0 +1,272 @@
+// Package sessions manages stateful sessions: each session keeps a language
+// kernel running in its own sandbox, so that code evaluated in one cell can
+// use the state left by the previous ones, until the session is deleted or
+// expires.
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/isavita/codeexec/internal/executor"
+)
+
+const (
+	// defaultIdleTimeout is used when Config leaves IdleTimeout unset.
+	defaultIdleTimeout = 15 * time.Minute
+	// defaultMaxSessions is used when Config leaves MaxSessions unset.
+	defaultMaxSessions = 50
+)
+
+var (
+	// ErrTooManySessions is returned by Create when the maximum number of
+	// sessions are open.
+	ErrTooManySessions = errors.New("too many sessions")
+	// ErrNotFound is returned for unknown, expired or foreign sessions.
+	ErrNotFound = errors.New("session not found")
+	// ErrClosed is returned by Create once the manager is closed.
+	ErrClosed = errors.New("session manager is closed")
+)
+
+// Session is a snapshot of a session.
+type Session struct {
+	ID         string    `json:"id"`
+	Language   string    `json:"language"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	// ExpiresAt is when the session is deleted unless it is used again.
+	ExpiresAt time.Time `json:"expires_at"`
+	// Cells counts the cells evaluated so far.
+	Cells int `json:"cells"`
+}
+
+// Config configures a Manager.
+type Config struct {
+	// IdleTimeout deletes sessions unused for that long. Defaults to 15
+	// minutes.
+	IdleTimeout time.Duration
+	// MaxSessions is the number of sessions that may be open at once, each
+	// of which holds a sandbox. Defaults to 50.
+	MaxSessions int
+}
+
+// session is the manager's record of a session.
+type session struct {
+	Session
+	// owner is the name of the API key that created the session. Only the
+	// same owner can use or delete it.
+	owner  string
+	kernel executor.Kernel
+	// running counts the cells being evaluated, which keep the session from
+	// expiring.
+	running int
+}
+
+// Manager keeps the sessions of an executor's kernels.
+type Manager struct {
+	exec   executor.KernelExecutor
+	config Config
+
+	mu       sync.Mutex
+	sessions map[string]*session
+	// starting counts the sessions whose kernel is starting, which count
+	// towards MaxSessions.
+	starting int
+	closed   bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New starts a manager, which deletes idle sessions in the background.
+func New(exec executor.KernelExecutor, config Config) *Manager {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = defaultIdleTimeout
+	}
+	if config.MaxSessions <= 0 {
+		config.MaxSessions = defaultMaxSessions
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		exec:     exec,
+		config:   config,
+		sessions: make(map[string]*session),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	m.wg.Add(1)
+	go m.expire()
+	return m
+}
+
+// Create starts a session of the request's language on behalf of owner.
+func (m *Manager) Create(ctx context.Context, req executor.Request, owner string) (Session, error) {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return Session{}, ErrClosed
+	}
+	if len(m.sessions)+m.starting >= m.config.MaxSessions {
+		m.mu.Unlock()
+		return Session{}, ErrTooManySessions
+	}
+	m.starting++
+	m.mu.Unlock()
+
+	kernel, err := m.exec.StartKernel(ctx, req)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.starting--
+	if err != nil {
+		return Session{}, err
+	}
+	if m.closed {
+		kernel.Close()
+		return Session{}, ErrClosed
+	}
+	now := time.Now()
+	s := &session{
+		Session: Session{
+			ID:         newID(),
+			Language:   req.Language,
+			CreatedAt:  now,
+			LastUsedAt: now,
+			ExpiresAt:  now.Add(m.config.IdleTimeout),
+		},
+		owner:  owner,
+		kernel: kernel,
+	}
+	m.sessions[s.ID] = s
+	return s.Session, nil
+}
+
+// Get returns the session with the given ID if it belongs to owner.
+func (m *Manager) Get(id, owner string) (Session, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.owner != owner {
+		return Session{}, ErrNotFound
+	}
+	return s.Session, nil
+}
+
+// Execute evaluates a cell in the session with the given ID if it belongs
+// to owner. Cells of a session are evaluated one at a time. A session whose
+// kernel exited is deleted.
+func (m *Manager) Execute(ctx context.Context, id, owner, code string) (*executor.CellResult, error) {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	if !ok || s.owner != owner {
+		m.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	s.running++
+	m.mu.Unlock()
+
+	result, err := s.kernel.Execute(ctx, code)
+
+	m.mu.Lock()
+	s.running--
+	now := time.Now()
+	s.LastUsedAt = now
+	s.ExpiresAt = now.Add(m.config.IdleTimeout)
+	s.Cells++
+	exited := errors.Is(err, executor.ErrKernelExited) || (result != nil && result.KernelExited)
+	deleted := m.sessions[id] != s
+	if exited && !deleted {
+		delete(m.sessions, id)
+	}
+	m.mu.Unlock()
+
+	if exited {
+		s.kernel.Close()
+	}
+	if exited && deleted && err != nil {
+		// The session was deleted while the cell was evaluated
+		return nil, ErrNotFound
+	}
+	return result, err
+}
+
+// Delete deletes the session with the given ID if it belongs to owner,
+// killing its kernel along with any cell being evaluated.
+func (m *Manager) Delete(id, owner string) error {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	if !ok || s.owner != owner {
+		m.mu.Unlock()
+		return ErrNotFound
+	}
+	delete(m.sessions, id)
+	m.mu.Unlock()
+
+	s.kernel.Close()
+	return nil
+}
+
+// Close stops accepting sessions and deletes the open ones.
+func (m *Manager) Close() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	sessions := m.sessions
+	m.sessions = make(map[string]*session)
+	m.mu.Unlock()
+
+	m.cancel()
+	m.wg.Wait()
+	for _, s := range sessions {
+		s.kernel.Close()
+	}
+}
+
+// expire periodically deletes the sessions that have been idle for longer
+// than the idle timeout.
+func (m *Manager) expire() {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.config.IdleTimeout / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var expired []*session
+		m.mu.Lock()
+		now := time.Now()
+		for id, s := range m.sessions {
+			if s.running == 0 && now.After(s.ExpiresAt) {
+				delete(m.sessions, id)
+				expired = append(expired, s)
+			}
+		}
+		m.mu.Unlock()
+
+		for _, s := range expired {
+			s.kernel.Close()
+		}
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("sessions: failed to generate ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
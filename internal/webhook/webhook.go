@@ -0,0 +1,243 @@
+// Package webhook delivers signed JSON payloads to client-provided callback
+// URLs, retrying failed deliveries with exponential backoff.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isavita/codeexec/internal/egress"
+)
+
+const (
+	// SignatureHeader carries the payload's HMAC-SHA256 signature, as
+	// "sha256=" followed by the hex-encoded MAC of the timestamp, a dot and
+	// the body.
+	SignatureHeader = "X-Codeexec-Signature"
+	// TimestampHeader carries the Unix time at which an attempt was signed.
+	TimestampHeader = "X-Codeexec-Timestamp"
+	// DeliveryHeader identifies a delivery. It is the same for every attempt,
+	// letting receivers ignore duplicates.
+	DeliveryHeader = "X-Codeexec-Delivery"
+
+	// defaultMaxAttempts is used when Config leaves MaxAttempts unset.
+	defaultMaxAttempts = 5
+	// defaultBackoff is used when Config leaves Backoff unset.
+	defaultBackoff = time.Second
+	// maxBackoff caps the wait between attempts.
+	maxBackoff = time.Minute
+	// defaultTimeout is used when Config leaves Timeout unset.
+	defaultTimeout = 10 * time.Second
+	// maxResponseSize bounds how much of a receiver's response is read.
+	maxResponseSize = 64 << 10
+)
+
+// ErrInvalidSignature is returned by Verify for payloads not signed with the
+// secret.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Config configures a Sender.
+type Config struct {
+	// Secret signs payloads. Payloads are sent unsigned if it is empty.
+	Secret []byte
+	// MaxAttempts is the number of attempts made before giving up.
+	// Defaults to 5.
+	MaxAttempts int
+	// Backoff is the wait before the first retry, doubled after every
+	// further attempt up to a minute. Defaults to a second.
+	Backoff time.Duration
+	// Timeout bounds each attempt. Defaults to 10 seconds.
+	Timeout time.Duration
+	// AllowPrivate lets payloads be delivered to loopback, private and
+	// link-local addresses. Otherwise they are refused, so that callback URLs
+	// cannot reach the server's host or its network, such as a Docker API
+	// listening on localhost or a cloud metadata service.
+	AllowPrivate bool
+}
+
+// Attempt records one attempt at delivering a payload.
+type Attempt struct {
+	Attempt    int       `json:"attempt"`
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// Succeeded reports whether the receiver accepted the payload.
+func (a Attempt) Succeeded() bool {
+	return a.Error == ""
+}
+
+// Sender delivers payloads.
+type Sender struct {
+	config Config
+	client *http.Client
+}
+
+func New(config Config) *Sender {
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultMaxAttempts
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaultBackoff
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	// The addresses are checked as they are dialed, so that a host name
+	// resolving to a private address is refused too
+	dialer := &net.Dialer{Timeout: config.Timeout}
+	if !config.AllowPrivate {
+		dialer.Control = egress.RefusePrivate
+	}
+	return &Sender{
+		config: config,
+		client: &http.Client{
+			Transport: &http.Transport{
+				// A proxy would be dialed instead of the receiver
+				Proxy:               nil,
+				DialContext:         dialer.DialContext,
+				TLSHandshakeTimeout: config.Timeout,
+			},
+			// A redirect could take a signed payload to another host
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// ValidateURL checks that rawURL is an absolute http or https URL. Unless
+// private addresses are allowed, it also refuses URLs naming localhost or a
+// loopback, private or link-local address. Host names resolving to such
+// addresses are only refused when a delivery is attempted.
+func (s *Sender) ValidateURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid callback_url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid callback_url %q: expected an absolute http or https URL", rawURL)
+	}
+	if s.config.AllowPrivate {
+		return nil
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("invalid callback_url %q: private addresses are not allowed", rawURL)
+	}
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil {
+		if err := egress.RefusePrivate("tcp", net.JoinHostPort(addr.String(), "0"), nil); err != nil {
+			return fmt.Errorf("invalid callback_url %q: private addresses are not allowed", rawURL)
+		}
+	}
+	return nil
+}
+
+// Deliver POSTs payload to rawURL until a receiver responds with a 2xx
+// status, the attempts run out or ctx is done. record is called after every
+// attempt. It returns the error of the last attempt if none succeeded.
+func (s *Sender) Deliver(ctx context.Context, rawURL string, payload []byte, record func(Attempt)) error {
+	delivery := newDeliveryID()
+	backoff := s.config.Backoff
+	var err error
+	for n := 1; ; n++ {
+		attempt := s.attempt(ctx, rawURL, delivery, payload)
+		attempt.Attempt = n
+		record(attempt)
+		if attempt.Succeeded() {
+			return nil
+		}
+		err = errors.New(attempt.Error)
+		if n == s.config.MaxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+		backoff = min(2*backoff, maxBackoff)
+	}
+}
+
+func (s *Sender) attempt(ctx context.Context, rawURL, delivery string, payload []byte) Attempt {
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	attempt := Attempt{At: start}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rawURL, bytes.NewReader(payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	timestamp := strconv.FormatInt(start.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "codeexec-webhook")
+	req.Header.Set(DeliveryHeader, delivery)
+	req.Header.Set(TimestampHeader, timestamp)
+	if len(s.config.Secret) > 0 {
+		req.Header.Set(SignatureHeader, Sign(s.config.Secret, timestamp, payload))
+	}
+
+	resp, err := s.client.Do(req)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, maxResponseSize))
+	resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		attempt.Error = "unexpected status: " + resp.Status
+	}
+	return attempt
+}
+
+// Sign returns the signature header value of a payload sent at timestamp.
+func Sign(secret []byte, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature headers of a received payload. Receivers
+// should also reject timestamps too far in the past to prevent replays.
+func Verify(secret []byte, header http.Header, payload []byte) error {
+	expected := Sign(secret, header.Get(TimestampHeader), payload)
+	if !hmac.Equal([]byte(expected), []byte(header.Get(SignatureHeader))) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("webhook: failed to generate delivery ID: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
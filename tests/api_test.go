@@ -9,8 +9,19 @@ import (
 	"testing"
 
 	"github.com/isavita/codeexec/cmd/api/server"
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/executor/fake"
+	"github.com/isavita/codeexec/internal/language"
 )
 
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	exec := fake.New().
+		On("print('Hello, World!')", fake.Output("Hello, World!\n")).
+		On("print('Hello, World!)", fake.SyntaxError("SyntaxError: unterminated string literal (detected at line 1)"))
+	return server.NewServer(exec, language.Default())
+}
+
 func TestCodeExecutionEndpoint(t *testing.T) {
 	// Test case: Successful execution
 	t.Run("SuccessfulExecution", func(t *testing.T) {
@@ -33,7 +44,7 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 		recorder := httptest.NewRecorder()
 
 		// Create a new API server
-		server := server.NewServer()
+		server := newTestServer(t)
 
 		// Serve the HTTP request to the API server
 		server.ServeHTTP(recorder, req)
@@ -44,15 +55,15 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 		}
 
 		// Check the response body
-		var response map[string]string
+		var response executor.Result
 		err = json.Unmarshal(recorder.Body.Bytes(), &response)
 		if err != nil {
 			t.Fatalf("Failed to unmarshal response body: %v", err)
 		}
 
-		expectedOutput := "Hello, World!"
-		if response["output"] != expectedOutput {
-			t.Errorf("Expected output %q, but got %q", expectedOutput, response["output"])
+		expectedOutput := "Hello, World!\n"
+		if response.Stdout != expectedOutput {
+			t.Errorf("Expected output %q, but got %q", expectedOutput, response.Stdout)
 		}
 	})
 	// Test case: Unsupported language
@@ -73,7 +84,7 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 
-		srv := server.NewServer()
+		srv := newTestServer(t)
 
 		srv.ServeHTTP(recorder, req)
 
@@ -110,7 +121,7 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 
-		srv := server.NewServer()
+		srv := newTestServer(t)
 
 		srv.ServeHTTP(recorder, req)
 
@@ -147,7 +158,7 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 
-		srv := server.NewServer()
+		srv := newTestServer(t)
 
 		srv.ServeHTTP(recorder, req)
 
@@ -185,7 +196,7 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 
-		srv := server.NewServer()
+		srv := newTestServer(t)
 
 		srv.ServeHTTP(recorder, req)
 
@@ -193,14 +204,17 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 			t.Errorf("Expected status code %d, but got %d", http.StatusOK, recorder.Code)
 		}
 
-		var response map[string]string
+		var response executor.Result
 		err = json.Unmarshal(recorder.Body.Bytes(), &response)
 		if err != nil {
 			t.Fatalf("Failed to unmarshal response body: %v", err)
 		}
 
-		if response["error"] == "" {
-			t.Error("Expected an error message, but got none")
+		if response.SyntaxCheck.Passed {
+			t.Error("Expected the syntax check to fail, but it passed")
+		}
+		if response.SyntaxCheck.Output == "" {
+			t.Error("Expected syntax check output, but got none")
 		}
 	})
 
@@ -223,7 +237,7 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 
-		srv := server.NewServer()
+		srv := newTestServer(t)
 
 		// Set the API key check enabled and provide an incorrect API key
 		os.Setenv("API_KEY_CHECK_ENABLED", "true")
@@ -269,7 +283,7 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 
-		srv := server.NewServer()
+		srv := newTestServer(t)
 
 		// Set the API key check enabled and provide the correct API key
 		os.Setenv("API_KEY_CHECK_ENABLED", "true")
@@ -285,15 +299,15 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 			t.Errorf("Expected status code %d, but got %d", http.StatusOK, recorder.Code)
 		}
 
-		var response map[string]string
+		var response executor.Result
 		err = json.Unmarshal(recorder.Body.Bytes(), &response)
 		if err != nil {
 			t.Fatalf("Failed to unmarshal response body: %v", err)
 		}
 
-		expectedOutput := "Hello, World!"
-		if response["output"] != expectedOutput {
-			t.Errorf("Expected output %q, but got %q", expectedOutput, response["output"])
+		expectedOutput := "Hello, World!\n"
+		if response.Stdout != expectedOutput {
+			t.Errorf("Expected output %q, but got %q", expectedOutput, response.Stdout)
 		}
 	})
 
@@ -315,7 +329,7 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 
-		srv := server.NewServer()
+		srv := newTestServer(t)
 
 		// Set the API key check disabled
 		os.Setenv("API_KEY_CHECK_ENABLED", "false")
@@ -327,15 +341,15 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 			t.Errorf("Expected status code %d, but got %d", http.StatusOK, recorder.Code)
 		}
 
-		var response map[string]string
+		var response executor.Result
 		err = json.Unmarshal(recorder.Body.Bytes(), &response)
 		if err != nil {
 			t.Fatalf("Failed to unmarshal response body: %v", err)
 		}
 
-		expectedOutput := "Hello, World!"
-		if response["output"] != expectedOutput {
-			t.Errorf("Expected output %q, but got %q", expectedOutput, response["output"])
+		expectedOutput := "Hello, World!\n"
+		if response.Stdout != expectedOutput {
+			t.Errorf("Expected output %q, but got %q", expectedOutput, response.Stdout)
 		}
 	})
 	// Test case: Invalid JSON payload
@@ -349,7 +363,7 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 
-		srv := server.NewServer()
+		srv := newTestServer(t)
 
 		srv.ServeHTTP(recorder, req)
 
@@ -377,7 +391,7 @@ func TestCodeExecutionEndpoint(t *testing.T) {
 
 		recorder := httptest.NewRecorder()
 
-		srv := server.NewServer()
+		srv := newTestServer(t)
 
 		srv.ServeHTTP(recorder, req)
 
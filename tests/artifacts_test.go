@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/isavita/codeexec/internal/executor"
+)
+
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory for %s: %v", name, err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+}
+
+func TestCollectArtifacts(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "code.py", "print(1)")
+	writeFile(t, dir, "data/input.txt", "1 2 3")
+	writeFile(t, dir, "data/results.txt", "old")
+
+	snapshot, err := executor.SnapshotWorkspace(dir)
+	if err != nil {
+		t.Fatalf("Failed to snapshot workspace: %v", err)
+	}
+
+	// Simulate the program's side effects
+	writeFile(t, dir, "data/results.txt", "new")
+	writeFile(t, dir, "out/report.txt", "Hello, World!\n")
+	writeFile(t, dir, "out/image.png", "\x89PNG\r\n\x1a\n\x00\xff")
+	writeFile(t, dir, "out/large.txt", strings.Repeat("x", 1<<20+1))
+	if err := os.Symlink("/etc/passwd", filepath.Join(dir, "out", "passwd")); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	artifacts, truncated, err := executor.CollectArtifacts(dir, snapshot)
+	if err != nil {
+		t.Fatalf("Failed to collect artifacts: %v", err)
+	}
+	if truncated {
+		t.Error("Expected every artifact to be listed")
+	}
+
+	byPath := make(map[string]executor.Artifact)
+	for _, artifact := range artifacts {
+		byPath[artifact.Path] = artifact
+	}
+	if len(byPath) != 4 {
+		t.Errorf("Expected 4 artifacts, but got %+v", artifacts)
+	}
+
+	if _, ok := byPath["code.py"]; ok {
+		t.Error("Expected unchanged files not to be reported")
+	}
+	if _, ok := byPath["out/passwd"]; ok {
+		t.Error("Expected symlinks not to be reported")
+	}
+	if got := byPath["data/results.txt"]; got.Content != "new" || got.Encoding != "utf-8" {
+		t.Errorf("Expected the modified file to be reported, but got %+v", got)
+	}
+	if got := byPath["out/report.txt"]; got.Content != "Hello, World!\n" || got.Size != 14 {
+		t.Errorf("Unexpected text artifact: %+v", got)
+	}
+	image := byPath["out/image.png"]
+	if image.Encoding != "base64" {
+		t.Errorf("Expected binary artifacts to be base64 encoded, but got %q", image.Encoding)
+	}
+	if decoded, _ := base64.StdEncoding.DecodeString(image.Content); string(decoded) != "\x89PNG\r\n\x1a\n\x00\xff" {
+		t.Errorf("Unexpected binary artifact content: %q", decoded)
+	}
+	if got := byPath["out/large.txt"]; !got.Omitted || got.Content != "" || got.Size != 1<<20+1 {
+		t.Errorf("Expected the oversized artifact to be omitted, but got size %d omitted %v", got.Size, got.Omitted)
+	}
+}
+
+func TestCollectArtifactsCount(t *testing.T) {
+	dir := t.TempDir()
+	snapshot, err := executor.SnapshotWorkspace(dir)
+	if err != nil {
+		t.Fatalf("Failed to snapshot workspace: %v", err)
+	}
+
+	for i := 0; i < 25; i++ {
+		writeFile(t, dir, fmt.Sprintf("out/%02d.txt", i), "x")
+	}
+
+	artifacts, truncated, err := executor.CollectArtifacts(dir, snapshot)
+	if err != nil {
+		t.Fatalf("Failed to collect artifacts: %v", err)
+	}
+	if len(artifacts) != 20 || !truncated {
+		t.Fatalf("Expected 20 artifacts to be listed and the rest left out, but got %d (truncated %v)", len(artifacts), truncated)
+	}
+	for _, artifact := range artifacts {
+		if artifact.Omitted {
+			t.Errorf("Expected the content of every listed artifact, but got %+v", artifact)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/isavita/codeexec/cmd/api/server"
+	"github.com/isavita/codeexec/internal/auth"
+	"github.com/isavita/codeexec/internal/executor/fake"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+const keysConfig = `{
+  "keys": [
+    {"name": "editor", "key": "editor-key", "max_limits": {"timeout": "10s", "memory_mb": 128}},
+    {"name": "notebooks", "key": "notebooks-key"}
+  ]
+}`
+
+func TestParseKeys(t *testing.T) {
+	keys, err := auth.Parse([]byte(keysConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse keys: %v", err)
+	}
+
+	key, ok := keys.Lookup("editor-key")
+	if !ok || key.Name != "editor" || key.MaxLimits.MemoryMB != 128 {
+		t.Errorf("Unexpected key for editor-key: %+v", key)
+	}
+	if _, ok := keys.Lookup("editor"); ok {
+		t.Error("Expected key names not to be accepted as keys")
+	}
+	if _, ok := keys.Lookup(""); ok {
+		t.Error("Expected an empty key to be rejected")
+	}
+
+	invalid := map[string]string{
+		"NoKeys":        `{"keys": []}`,
+		"MissingKey":    `{"keys": [{"name": "editor"}]}`,
+		"Duplicate":     `{"keys": [{"name": "editor", "key": "a"}, {"name": "editor", "key": "b"}]}`,
+		"NegativeLimit": `{"keys": [{"name": "editor", "key": "a", "max_limits": {"memory_mb": -1}}]}`,
+	}
+	for name, config := range invalid {
+		t.Run(name, func(t *testing.T) {
+			if _, err := auth.Parse([]byte(config)); err == nil {
+				t.Error("Expected an error, but got nil")
+			}
+		})
+	}
+}
+
+func TestServerAPIKeys(t *testing.T) {
+	keys, err := auth.Parse([]byte(keysConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse keys: %v", err)
+	}
+	exec := fake.New().Default(fake.Output(""))
+	srv := server.NewServer(exec, language.Default(), server.WithAPIKeys(keys))
+
+	serve := func(apiKey string, limits map[string]any) *httptest.ResponseRecorder {
+		t.Helper()
+		body, _ := json.Marshal(map[string]any{"code": "print(1)", "language": "python", "limits": limits})
+		req := httptest.NewRequest("POST", "/api/execute", bytes.NewReader(body))
+		req.Header.Set("X-Api-Key", apiKey)
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	if recorder := serve("unknown-key", nil); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for an unknown key, but got %d", http.StatusUnauthorized, recorder.Code)
+	}
+
+	// The editor key caps memory below the language's maximum
+	recorder := serve("editor-key", map[string]any{"memory_mb": 512})
+	if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), "memory_mb exceeds the maximum of 128") {
+		t.Errorf("Expected the key's maximum to apply, but got %d: %s", recorder.Code, recorder.Body.String())
+	}
+	if recorder := serve("editor-key", map[string]any{"memory_mb": 128}); recorder.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+
+	// The notebooks key is only bound by the language's maximum
+	if recorder := serve("notebooks-key", map[string]any{"memory_mb": 512}); recorder.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestServerNetworkPolicy(t *testing.T) {
+	keys, err := auth.Parse([]byte(`{
+  "keys": [
+    {"name": "offline", "key": "offline-key"},
+    {"name": "packages", "key": "packages-key", "network": {"allowed_hosts": ["pypi.org", "*.pythonhosted.org"]}},
+    {"name": "scraper", "key": "scraper-key", "network": {"allowed_hosts": ["*.example.com"], "default": true}}
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("Failed to parse keys: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		apiKey   string
+		network  map[string]any
+		status   int
+		expected []string
+	}{
+		{name: "OfflineByDefault", apiKey: "offline-key", status: http.StatusOK},
+		{name: "NotPermitted", apiKey: "offline-key", network: map[string]any{"allowed_hosts": []string{"pypi.org"}}, status: http.StatusForbidden},
+		{name: "OptIn", apiKey: "packages-key", network: map[string]any{"allowed_hosts": []string{"pypi.org", "files.pythonhosted.org"}}, status: http.StatusOK, expected: []string{"pypi.org", "files.pythonhosted.org"}},
+		{name: "OutsidePolicy", apiKey: "packages-key", network: map[string]any{"allowed_hosts": []string{"github.com"}}, status: http.StatusForbidden},
+		{name: "InvalidPattern", apiKey: "packages-key", network: map[string]any{"allowed_hosts": []string{"https://pypi.org"}}, status: http.StatusBadRequest},
+		{name: "KeyDefault", apiKey: "scraper-key", status: http.StatusOK, expected: []string{"*.example.com"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exec := fake.New().Default(fake.Output(""))
+			srv := server.NewServer(exec, language.Default(), server.WithAPIKeys(keys))
+
+			fields := map[string]any{"code": "print(1)", "language": "python"}
+			if tc.network != nil {
+				fields["network"] = tc.network
+			}
+			body, _ := json.Marshal(fields)
+			req := httptest.NewRequest("POST", "/api/execute", bytes.NewReader(body))
+			req.Header.Set("X-Api-Key", tc.apiKey)
+			recorder := httptest.NewRecorder()
+			srv.ServeHTTP(recorder, req)
+
+			if recorder.Code != tc.status {
+				t.Fatalf("Expected status code %d, but got %d: %s", tc.status, recorder.Code, recorder.Body.String())
+			}
+			if tc.status != http.StatusOK {
+				return
+			}
+			network := exec.Requests()[0].Network
+			if tc.expected == nil {
+				if network != nil {
+					t.Errorf("Expected no network access, but got %+v", network)
+				}
+				return
+			}
+			if network == nil || strings.Join(network.AllowedHosts, ",") != strings.Join(tc.expected, ",") {
+				t.Errorf("Expected access to %v, but got %+v", tc.expected, network)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+func TestParseDiagnostics(t *testing.T) {
+	testCases := []struct {
+		name     string
+		language string
+		output   string
+		expected []executor.Diagnostic
+	}{
+		{
+			name:     "Python",
+			language: "python",
+			output:   "code.py:1:7: unterminated string literal (detected at line 1)\n",
+			expected: []executor.Diagnostic{{File: "code.py", Line: 1, Column: 7, Message: "unterminated string literal (detected at line 1)"}},
+		},
+		{
+			name:     "JavaScript",
+			language: "javascript",
+			output: "/app/code.js:1\n" +
+				"console.log('Hello, JavaScript!';\n" +
+				"            ^^^^^^^^^^^^^^^^^^^^\n" +
+				"\n" +
+				"SyntaxError: missing ) after argument list\n" +
+				"    at internalCompileFunction (node:internal/vm:73:18)\n",
+			expected: []executor.Diagnostic{{File: "code.js", Line: 1, Column: 13, Message: "SyntaxError: missing ) after argument list"}},
+		},
+		{
+			name:     "C",
+			language: "c",
+			output: "main.c: In function 'main':\n" +
+				"main.c:2:16: error: 'undefined_variable' undeclared (first use in this function)\n" +
+				"    2 |         return undefined_variable;\n" +
+				"      |                ^~~~~~~~~~~~~~~~~~\n",
+			expected: []executor.Diagnostic{{File: "main.c", Line: 2, Column: 16, Message: "error: 'undefined_variable' undeclared (first use in this function)"}},
+		},
+		{
+			name:     "Go",
+			language: "go",
+			output:   "# command-line-arguments\n./main.go:6:6: undefined: fmt.Printn\n",
+			expected: []executor.Diagnostic{{File: "main.go", Line: 6, Column: 6, Message: "undefined: fmt.Printn"}},
+		},
+		{
+			name:     "Rust",
+			language: "rust",
+			output: "error[E0425]: cannot find value `x` in this scope\n" +
+				" --> main.rs:2:20\n" +
+				"  |\n" +
+				"2 |     println!(\"{}\", x);\n" +
+				"  |                    ^ not found in this scope\n",
+			expected: []executor.Diagnostic{{File: "main.rs", Line: 2, Column: 20, Message: "error[E0425]: cannot find value `x` in this scope"}},
+		},
+		{
+			name:     "Java",
+			language: "java",
+			output: "Main.java:3: error: ';' expected\n" +
+				"\t\tSystem.out.println(\"Hello\")\n" +
+				"\t\t                           ^\n" +
+				"1 error\n",
+			expected: []executor.Diagnostic{{File: "Main.java", Line: 3, Column: 0, Message: "error: ';' expected"}},
+		},
+		{
+			name:     "Unparseable",
+			language: "python",
+			output:   "Segmentation fault\n",
+			expected: nil,
+		},
+	}
+
+	registry := language.Default()
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			lang, ok := registry.Lookup(tc.language)
+			if !ok {
+				t.Fatalf("Language %s not configured", tc.language)
+			}
+
+			diagnostics := executor.ParseDiagnostics(lang, tc.output)
+			if !reflect.DeepEqual(diagnostics, tc.expected) {
+				t.Errorf("Expected diagnostics %+v, but got %+v", tc.expected, diagnostics)
+			}
+		})
+	}
+}
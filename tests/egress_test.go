@@ -0,0 +1,153 @@
+package tests
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/isavita/codeexec/internal/egress"
+)
+
+// proxyClient returns a client sending its requests through the proxy with
+// the given token, trusting the stand-in TLS server if one is given.
+func proxyClient(t *testing.T, proxy *httptest.Server, token string, tlsServer *httptest.Server) *http.Client {
+	t.Helper()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse proxy URL: %v", err)
+	}
+	if token != "" {
+		proxyURL.User = url.User(token)
+	}
+	transport := &http.Transport{}
+	if tlsServer != nil {
+		transport = tlsServer.Client().Transport.(*http.Transport).Clone()
+	}
+	transport.Proxy = http.ProxyURL(proxyURL)
+	return &http.Client{Transport: transport}
+}
+
+func TestEgressProxy(t *testing.T) {
+	// Stand-ins for the hosts programs connect to
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "plain %s", r.URL.Path)
+	}))
+	defer upstream.Close()
+	tlsUpstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "tls %s", r.URL.Path)
+	}))
+	defer tlsUpstream.Close()
+
+	proxy := egress.NewProxy(true)
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+
+	get := func(client *http.Client, target string) (int, string) {
+		t.Helper()
+		resp, err := client.Get(target)
+		if err != nil {
+			// Failed CONNECT requests surface as errors naming the status
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	token, revoke := proxy.Grant([]string{"127.0.0.1"})
+	client := proxyClient(t, proxyServer, token, tlsUpstream)
+
+	if status, body := get(client, upstream.URL+"/hello"); status != http.StatusOK || body != "plain /hello" {
+		t.Errorf("Expected the plain request to be forwarded, but got %d %q", status, body)
+	}
+	if status, body := get(client, tlsUpstream.URL+"/hello"); status != http.StatusOK || body != "tls /hello" {
+		t.Errorf("Expected the request to be tunnelled, but got %d %q", status, body)
+	}
+
+	// Hosts outside the grant are refused
+	otherToken, revokeOther := proxy.Grant([]string{"example.com"})
+	defer revokeOther()
+	other := proxyClient(t, proxyServer, otherToken, tlsUpstream)
+	if status, _ := get(other, upstream.URL); status != http.StatusForbidden {
+		t.Errorf("Expected status code %d for a host outside the grant, but got %d", http.StatusForbidden, status)
+	}
+	if _, body := get(other, tlsUpstream.URL); !strings.Contains(body, "Forbidden") {
+		t.Errorf("Expected the tunnel to a host outside the grant to be refused, but got %q", body)
+	}
+
+	// Requests without a valid token are refused
+	if status, _ := get(proxyClient(t, proxyServer, "", tlsUpstream), upstream.URL); status != http.StatusProxyAuthRequired {
+		t.Errorf("Expected status code %d without a token, but got %d", http.StatusProxyAuthRequired, status)
+	}
+	revoke()
+	if status, _ := get(client, upstream.URL); status != http.StatusProxyAuthRequired {
+		t.Errorf("Expected status code %d after revoking, but got %d", http.StatusProxyAuthRequired, status)
+	}
+}
+
+func TestEgressProxyRefusesPrivateAddresses(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer upstream.Close()
+
+	proxy := egress.NewProxy(false)
+	proxyServer := httptest.NewServer(proxy)
+	defer proxyServer.Close()
+
+	token, revoke := proxy.Grant([]string{"*"})
+	defer revoke()
+	resp, err := proxyClient(t, proxyServer, token, nil).Get(upstream.URL)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("Expected status code %d for a loopback address, but got %d", http.StatusBadGateway, resp.StatusCode)
+	}
+}
+
+func TestEgressPatterns(t *testing.T) {
+	patterns := []string{"pypi.org", "*.example.com"}
+
+	allowed := map[string]bool{
+		"pypi.org":          true,
+		"PyPI.org":          true,
+		"files.pypi.org":    false,
+		"api.example.com":   true,
+		"a.b.example.com":   true,
+		"example.com":       false,
+		"evilexample.com":   false,
+		"example.com.evil":  false,
+		"pythonhosted.org.": false,
+	}
+	for host, expected := range allowed {
+		if egress.Allowed(patterns, host) != expected {
+			t.Errorf("Expected Allowed(%q) to be %v", host, expected)
+		}
+	}
+
+	covered := map[string]bool{
+		"pypi.org":          true,
+		"*.example.com":     true,
+		"*.api.example.com": true,
+		"*.org":             false,
+		"*":                 false,
+	}
+	for pattern, expected := range covered {
+		if egress.Covers(patterns, pattern) != expected {
+			t.Errorf("Expected Covers(%q) to be %v", pattern, expected)
+		}
+	}
+	if !egress.Covers([]string{"*"}, "*") {
+		t.Error("Expected * to cover itself")
+	}
+
+	for _, invalid := range []string{"", "*.", "http://pypi.org", "pypi.org:443", "*.*.org"} {
+		if err := egress.Check([]string{"*"}, []string{invalid}); err == nil {
+			t.Errorf("Expected pattern %q to be rejected", invalid)
+		}
+	}
+}
@@ -1,11 +1,18 @@
+//go:build docker
+
 package tests
 
 import (
+	"context"
+	"errors"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/judge"
+	"github.com/isavita/codeexec/internal/language"
 )
 
 const (
@@ -15,25 +22,37 @@ const (
 
 func TestDockerExecutor(t *testing.T) {
 	testCases := []struct {
-		name     string
-		code     string
-		language string
-		expected string
-		err      string
-		timeout  time.Duration
+		name        string
+		code        string
+		language    string
+		expected    string
+		exitCode    int
+		syntaxError bool
+		timedOut    bool
+		// memoryExceeded expects the run to fail for lack of memory
+		memoryExceeded bool
+		timeout        time.Duration
 	}{
 		{
 			name:     "SuccessfulExecution",
 			code:     "print('Hello, World!')",
 			language: "python",
-			expected: "Hello, World!",
+			expected: "Hello, World!\n",
 			timeout:  timeout,
 		},
 		{
-			name:     "SyntaxError",
-			code:     "print('Hello, World!",
+			name:        "SyntaxError",
+			code:        "print('Hello, World!",
+			language:    "python",
+			exitCode:    -1,
+			syntaxError: true,
+			timeout:     timeout,
+		},
+		{
+			name:     "TripleQuotedStrings",
+			code:     "print('''a''')\nprint(\"''')\")",
 			language: "python",
-			err:      "syntax check failed",
+			expected: "a\n''')\n",
 			timeout:  timeout,
 		},
 		{
@@ -47,7 +66,7 @@ print(a)
 a
 `,
 			language: "python",
-			expected: "5",
+			expected: "5\n",
 			timeout:  timeout,
 		},
 		{
@@ -60,7 +79,8 @@ time.sleep(5)
 print("Execution finished")
 `,
 			language: "python",
-			err:      "container execution timed out",
+			exitCode: -1,
+			timedOut: true,
 			timeout:  timeout,
 		},
 		{
@@ -73,40 +93,61 @@ large_array = np.zeros((10240000, 1024000, 1024000), dtype=np.int8)
 print("Allocation finished")
 `,
 			language: "python",
-			// TODO: return "container exceeded memory limit",
-			err:     "container exited with non-zero status code: 1",
-			timeout: extendedTimeout,
+			expected: "Allocating large array\n",
+			// numpy raises MemoryError rather than being OOM killed
+			exitCode:       1,
+			memoryExceeded: true,
+			timeout:        extendedTimeout,
+		},
+		{
+			name:           "OOMKilled",
+			code:           "data = []\nwhile True:\n    data.append(bytearray(1 << 20))",
+			language:       "python",
+			exitCode:       137,
+			memoryExceeded: true,
+			timeout:        extendedTimeout,
 		},
 		{
 			name:     "JavaScriptExecution",
 			code:     "console.log('Hello, JavaScript!');",
 			language: "javascript",
-			expected: "Hello, JavaScript!",
+			expected: "Hello, JavaScript!\n",
 			timeout:  timeout,
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			exec, err := executor.NewDockerExecutor()
+			exec, err := executor.NewDockerExecutor(language.Default())
 			if err != nil {
 				t.Fatalf("Failed to create Docker executor: %v", err)
 			}
 
-			result, err := exec.Execute(tc.code, tc.language, tc.timeout)
-			if tc.err != "" {
-				if err == nil {
-					t.Errorf("Expected an error containing '%s', but got nil", tc.err)
-				} else if !strings.Contains(err.Error(), tc.err) {
-					t.Errorf("Expected an error containing '%s', but got: %v", tc.err, err)
-				}
-			} else {
-				if err != nil {
-					t.Errorf("Unexpected error: %v", err)
-				}
-				if result != tc.expected {
-					t.Errorf("Expected output: %s, but got: %s", tc.expected, result)
-				}
+			result, err := exec.Execute(context.Background(), executor.Request{
+				Language: tc.language,
+				Code:     tc.code,
+				Limits:   language.Limits{Timeout: language.Duration(tc.timeout)},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.SyntaxCheck.Passed == tc.syntaxError {
+				t.Errorf("Expected syntax check passed to be %v, but got %v", !tc.syntaxError, result.SyntaxCheck.Passed)
+			}
+			if result.TimedOut != tc.timedOut {
+				t.Errorf("Expected timed out to be %v, but got %v", tc.timedOut, result.TimedOut)
+			}
+			if result.ExitCode != tc.exitCode {
+				t.Errorf("Expected exit code %d, but got %d", tc.exitCode, result.ExitCode)
+			}
+			if !tc.timedOut && result.Stdout != tc.expected {
+				t.Errorf("Expected output: %q, but got: %q", tc.expected, result.Stdout)
+			}
+			if result.MemoryExceeded != tc.memoryExceeded {
+				t.Errorf("Expected memory exceeded to be %v, but got %v (stderr %q)", tc.memoryExceeded, result.MemoryExceeded, result.Stderr)
+			}
+			if !result.TimedOut && result.PeakMemoryBytes <= 0 {
+				t.Error("Expected the peak memory usage to be reported")
 			}
 		})
 	}
@@ -123,23 +164,837 @@ func TestJavaScriptSyntaxCheck(t *testing.T) {
 			name:     "JavaScriptSyntaxError",
 			code:     "console.log('Hello, JavaScript!';",
 			language: "javascript",
-			err:      "syntax check failed",
+			err:      "SyntaxError",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exec, err := executor.NewDockerExecutor(language.Default())
+			if err != nil {
+				t.Fatalf("Failed to create Docker executor: %v", err)
+			}
+
+			result, err := exec.Execute(context.Background(), executor.Request{
+				Language: tc.language,
+				Code:     tc.code,
+				Limits:   language.Limits{Timeout: language.Duration(timeout)},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.SyntaxCheck.Passed {
+				t.Error("Expected the syntax check to fail, but it passed")
+			}
+			if !strings.Contains(result.SyntaxCheck.Output, tc.err) {
+				t.Errorf("Expected syntax check output containing '%s', but got: %s", tc.err, result.SyntaxCheck.Output)
+			}
+			if len(result.SyntaxCheck.Diagnostics) != 1 || result.SyntaxCheck.Diagnostics[0].Line != 1 {
+				t.Errorf("Expected one diagnostic on line 1, but got %+v", result.SyntaxCheck.Diagnostics)
+			}
+		})
+	}
+}
+
+func TestDockerExecutorCancellation(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), extendedTimeout)
+	defer cancel()
+
+	start := time.Now()
+	_, err = exec.Execute(ctx, executor.Request{
+		Language: "python",
+		Code:     "import time\ntime.sleep(60)",
+		Limits:   language.Limits{Timeout: language.Duration(time.Minute)},
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a cancellation error, but got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > extendedTimeout+5*time.Second {
+		t.Errorf("Expected the run to stop with its context, but it took %s", elapsed)
+	}
+}
+
+func TestDockerExecutorCompiledLanguages(t *testing.T) {
+	testCases := []struct {
+		name         string
+		code         string
+		language     string
+		expected     string
+		compileError string
+	}{
+		{
+			name:     "Go",
+			code:     "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"Hello, Go!\")\n}\n",
+			language: "go",
+			expected: "Hello, Go!\n",
+		},
+		{
+			name:     "C",
+			code:     "#include <stdio.h>\n\nint main(void) {\n\tprintf(\"Hello, C!\\n\");\n\treturn 0;\n}\n",
+			language: "c",
+			expected: "Hello, C!\n",
+		},
+		{
+			name:     "CPP",
+			code:     "#include <iostream>\n\nint main() {\n\tstd::cout << \"Hello, C++!\" << std::endl;\n}\n",
+			language: "cpp",
+			expected: "Hello, C++!\n",
+		},
+		{
+			name:     "Rust",
+			code:     "fn main() {\n    println!(\"Hello, Rust!\");\n}\n",
+			language: "rust",
+			expected: "Hello, Rust!\n",
+		},
+		{
+			name:     "Java",
+			code:     "public class Main {\n\tpublic static void main(String[] args) {\n\t\tSystem.out.println(\"Hello, Java!\");\n\t}\n}\n",
+			language: "java",
+			expected: "Hello, Java!\n",
+		},
+		{
+			name:         "CompileError",
+			code:         "int main(void) {\n\treturn undefined_variable;\n}\n",
+			language:     "c",
+			compileError: "undefined_variable",
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			exec, err := executor.NewDockerExecutor()
+			exec, err := executor.NewDockerExecutor(language.Default())
 			if err != nil {
 				t.Fatalf("Failed to create Docker executor: %v", err)
 			}
 
-			_, err = exec.Execute(tc.code, tc.language, timeout)
-			if err == nil {
-				t.Errorf("Expected an error containing '%s', but got nil", tc.err)
-			} else if !strings.Contains(err.Error(), tc.err) {
-				t.Errorf("Expected an error containing '%s', but got: %v", tc.err, err)
+			result, err := exec.Execute(context.Background(), executor.Request{
+				Language: tc.language,
+				Code:     tc.code,
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Compile == nil {
+				t.Fatal("Expected a compile result, but got none")
+			}
+
+			if tc.compileError != "" {
+				if result.Compile.Succeeded {
+					t.Error("Expected the compilation to fail")
+				}
+				if !strings.Contains(result.Compile.Stderr, tc.compileError) {
+					t.Errorf("Expected diagnostics containing '%s', but got: %s", tc.compileError, result.Compile.Stderr)
+				}
+				return
+			}
+
+			if !result.Compile.Succeeded {
+				t.Fatalf("Expected the compilation to succeed, but got: %s", result.Compile.Stderr)
+			}
+			if result.Stdout != tc.expected {
+				t.Errorf("Expected output: %q, but got: %q", tc.expected, result.Stdout)
 			}
 		})
 	}
 }
+
+func TestDockerExecutorStdin(t *testing.T) {
+	testCases := []struct {
+		name     string
+		code     string
+		language string
+		stdin    string
+		expected string
+	}{
+		{
+			name:     "Python",
+			code:     "a, b = map(int, input().split())\nprint(a + b)",
+			language: "python",
+			stdin:    "3 4\n",
+			expected: "7\n",
+		},
+		{
+			name:     "JavaScript",
+			code:     "const lines = require('fs').readFileSync(0, 'utf8').trim().split('\\n');\nconsole.log(lines.length);",
+			language: "javascript",
+			stdin:    "a\nb\nc\n",
+			expected: "3\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exec, err := executor.NewDockerExecutor(language.Default())
+			if err != nil {
+				t.Fatalf("Failed to create Docker executor: %v", err)
+			}
+
+			result, err := exec.Execute(context.Background(), executor.Request{
+				Language: tc.language,
+				Code:     tc.code,
+				Stdin:    tc.stdin,
+				Limits:   language.Limits{Timeout: language.Duration(extendedTimeout)},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Stdout != tc.expected {
+				t.Errorf("Expected output: %q, but got: %q", tc.expected, result.Stdout)
+			}
+		})
+	}
+}
+
+func TestDockerExecutorProjects(t *testing.T) {
+	testCases := []struct {
+		name     string
+		req      executor.Request
+		expected string
+	}{
+		{
+			name: "PythonPackage",
+			req: executor.Request{
+				Language:   "python",
+				Entrypoint: "app/main.py",
+				Files: map[string]string{
+					"app/main.py":          "from lib.greet import greet\nprint(greet(open('data/name.txt').read().strip()))",
+					"app/lib/__init__.py":  "",
+					"app/lib/greet.py":     "def greet(name):\n    return f'Hello, {name}!'",
+					"data/name.txt":        "World\n",
+					"data/unused/file.bin": "\x00\x01",
+				},
+			},
+			expected: "Hello, World!\n",
+		},
+		{
+			name: "GoMultipleFiles",
+			req: executor.Request{
+				Language: "go",
+				Files: map[string]string{
+					"main.go":   "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(add(2, 3))\n}\n",
+					"helper.go": "package main\n\nfunc add(a, b int) int {\n\treturn a + b\n}\n",
+				},
+			},
+			expected: "5\n",
+		},
+		{
+			name: "JavaPackages",
+			req: executor.Request{
+				Language:   "java",
+				Entrypoint: "app/Main.java",
+				Files: map[string]string{
+					"app/Main.java":     "package app;\n\nimport util.Greeter;\n\npublic class Main {\n\tpublic static void main(String[] args) {\n\t\tSystem.out.println(Greeter.greet(\"Java\"));\n\t}\n}\n",
+					"util/Greeter.java": "package util;\n\npublic class Greeter {\n\tpublic static String greet(String name) {\n\t\treturn \"Hello, \" + name + \"!\";\n\t}\n}\n",
+				},
+			},
+			expected: "Hello, Java!\n",
+		},
+		{
+			name: "CSpacesInPaths",
+			req: executor.Request{
+				Language: "c",
+				Files: map[string]string{
+					"main.c":           "int add(int a, int b);\n\n#include <stdio.h>\n\nint main(void) {\n\tprintf(\"%d\\n\", add(2, 3));\n\treturn 0;\n}\n",
+					"my lib/add one.c": "int add(int a, int b) {\n\treturn a + b;\n}\n",
+				},
+			},
+			expected: "5\n",
+		},
+		{
+			name: "CppSpacesInPaths",
+			req: executor.Request{
+				Language: "cpp",
+				Files: map[string]string{
+					"main.cpp":          "#include <iostream>\n\nint add(int a, int b);\n\nint main() {\n\tstd::cout << add(2, 3) << std::endl;\n}\n",
+					"my lib/add one.cc": "int add(int a, int b) {\n\treturn a + b;\n}\n",
+				},
+			},
+			expected: "5\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exec, err := executor.NewDockerExecutor(language.Default())
+			if err != nil {
+				t.Fatalf("Failed to create Docker executor: %v", err)
+			}
+
+			result, err := exec.Execute(context.Background(), tc.req)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Stdout != tc.expected {
+				t.Errorf("Expected output: %q, but got: %q (stderr: %s)", tc.expected, result.Stdout, result.Stderr)
+			}
+		})
+	}
+}
+
+func TestDockerExecutorArtifacts(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	result, err := exec.Execute(context.Background(), executor.Request{
+		Language: "python",
+		Code:     "import os\nos.makedirs('out', exist_ok=True)\nwith open('out/result.txt', 'w') as f:\n    f.write('42')\n",
+		Limits:   language.Limits{Timeout: language.Duration(extendedTimeout)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Artifacts) != 1 {
+		t.Fatalf("Expected 1 artifact, but got %+v", result.Artifacts)
+	}
+	if artifact := result.Artifacts[0]; artifact.Path != "out/result.txt" || artifact.Content != "42" {
+		t.Errorf("Unexpected artifact: %+v", artifact)
+	}
+	if result.ArtifactsTruncated {
+		t.Error("Expected every artifact to be copied out")
+	}
+
+	result, err = exec.Execute(context.Background(), executor.Request{
+		Language: "python",
+		Code:     "import os\nfor name in ('a.txt', 'secret.txt'):\n    with open(name, 'w') as f:\n        f.write(name)\nos.chmod('secret.txt', 0)\n",
+		Limits:   language.Limits{Timeout: language.Duration(extendedTimeout)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !result.ArtifactsTruncated {
+		t.Errorf("Expected an unreadable file to truncate the artifacts, but got %+v", result.Artifacts)
+	}
+}
+
+func TestDockerExecutorPool(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default(), executor.WithPool(executor.PoolConfig{
+		Sizes:               map[string]int{"python": 2},
+		HealthCheckInterval: 100 * time.Millisecond,
+	}))
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+	defer exec.Close()
+
+	// Each run must get a fresh sandbox, even when the pool is drained
+	for i := 0; i < 4; i++ {
+		result, err := exec.Execute(context.Background(), executor.Request{
+			Language: "python",
+			Code:     "import os\nprint(os.path.exists('state'))\nopen('state', 'w').close()",
+			Limits:   language.Limits{Timeout: language.Duration(extendedTimeout)},
+		})
+		if err != nil {
+			t.Fatalf("Run %d: unexpected error: %v", i, err)
+		}
+		if result.Stdout != "False\n" {
+			t.Errorf("Run %d: expected a clean workspace, but got stdout %q stderr %q", i, result.Stdout, result.Stderr)
+		}
+	}
+}
+
+func TestDockerExecutorLimits(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	result, err := exec.Execute(context.Background(), executor.Request{
+		Language: "python",
+		Code:     "import sys\nprint('x' * 10000)\nprint('y' * 10000, file=sys.stderr)",
+		Limits:   language.Limits{Timeout: language.Duration(extendedTimeout), OutputBytes: 100},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(result.Stdout) != 100 || len(result.Stderr) != 100 {
+		t.Errorf("Expected output to be capped at 100 bytes per stream, but got %d and %d", len(result.Stdout), len(result.Stderr))
+	}
+	if !result.StdoutTruncated || !result.StderrTruncated || result.StdoutBytes != 10001 || result.StderrBytes != 10001 {
+		t.Errorf("Expected truncated streams of 10001 bytes each, but got %+v", result)
+	}
+	if result.Limits.OutputBytes != 100 || result.Limits.MemoryMB != 64 {
+		t.Errorf("Expected the requested limits over the defaults, but got %+v", result.Limits)
+	}
+
+	result, err = exec.Execute(context.Background(), executor.Request{
+		Language: "python",
+		Code:     "import threading, time\nthreads = [threading.Thread(target=time.sleep, args=(1,)) for _ in range(20)]\nfor t in threads: t.start()\nprint('started')",
+		Limits:   language.Limits{Timeout: language.Duration(extendedTimeout), Pids: 8},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.ExitCode == 0 || !strings.Contains(result.Stderr, "can't start new thread") {
+		t.Errorf("Expected the process limit to stop the program, but got exit code %d stderr %q", result.ExitCode, result.Stderr)
+	}
+
+	result, err = exec.Execute(context.Background(), executor.Request{
+		Language: "python",
+		Code:     "import os, signal\nos.kill(os.getpid(), signal.SIGKILL)",
+		Limits:   language.Limits{Timeout: language.Duration(extendedTimeout)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.ExitCode != 137 || result.OOMKilled || result.MemoryExceeded {
+		t.Errorf("Expected a program killing itself not to be reported as OOM killed, but got %+v", result)
+	}
+}
+
+func TestDockerExecutorNetwork(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	result, err := exec.Execute(context.Background(), executor.Request{
+		Language: "python",
+		Code:     "import socket\ntry:\n    socket.create_connection(('1.1.1.1', 53), timeout=1)\n    print('connected')\nexcept OSError:\n    print('offline')",
+		Limits:   language.Limits{Timeout: language.Duration(extendedTimeout)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Stdout != "offline\n" {
+		t.Errorf("Expected no network access by default, but got %q", result.Stdout)
+	}
+
+	// Without an egress proxy, network access cannot be granted
+	_, err = exec.Execute(context.Background(), executor.Request{
+		Language: "python",
+		Code:     "print(1)",
+		Network:  &executor.Network{AllowedHosts: []string{"pypi.org"}},
+	})
+	if !errors.Is(err, executor.ErrInvalidRequest) {
+		t.Errorf("Expected an invalid request error, but got %v", err)
+	}
+}
+
+func TestDockerExecutorSecurity(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		files    map[string]string
+		code     string
+		expected string
+	}{
+		{
+			name:     "NonRootUser",
+			code:     "import os\nprint(os.getuid(), os.getgid())",
+			expected: "65534 65534\n",
+		},
+		{
+			name:     "ReadOnlyRoot",
+			code:     "try:\n    open('/etc/passwd', 'a')\n    print('written')\nexcept OSError:\n    print('denied')",
+			expected: "denied\n",
+		},
+		{
+			name:     "SubmittedFilesProtected",
+			files:    map[string]string{"data.txt": "original"},
+			code:     "import os\nfor attempt in (lambda: open('data.txt', 'w'), lambda: os.remove('data.txt'), lambda: open('code.py', 'w')):\n    try:\n        attempt()\n        print('tampered')\n    except OSError:\n        print('denied')\nopen('output.txt', 'w').write('ok')",
+			expected: "denied\ndenied\ndenied\n",
+		},
+		{
+			name:     "ForkBomb",
+			code:     "import os\nchildren = 0\ntry:\n    while True:\n        if os.fork() == 0:\n            os.pause()\n        children += 1\nexcept OSError:\n    print('stopped', children < 64)",
+			expected: "stopped True\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := exec.Execute(context.Background(), executor.Request{
+				Language: "python",
+				Code:     tc.code,
+				Files:    tc.files,
+				Limits:   language.Limits{Timeout: language.Duration(extendedTimeout)},
+			})
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if result.Stdout != tc.expected {
+				t.Errorf("Expected stdout %q, but got %q (stderr %q)", tc.expected, result.Stdout, result.Stderr)
+			}
+		})
+	}
+}
+
+func TestDockerExecutorRuntime(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+	result, err := exec.Execute(context.Background(), executor.Request{Language: "python", Code: "print(1)"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Runtime == "" {
+		t.Error("Expected the daemon's default runtime to be reported")
+	}
+
+	if _, err := executor.NewDockerExecutor(language.Default(), executor.WithRuntime("no-such-runtime")); err == nil {
+		t.Error("Expected an unregistered runtime to be rejected")
+	}
+}
+
+func TestDockerExecutorAccounting(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	busy, err := exec.Execute(context.Background(), executor.Request{
+		Language: "python",
+		Code:     "import time\nend = time.process_time() + 0.5\nwhile time.process_time() < end: pass",
+		Limits:   language.Limits{Timeout: language.Duration(5 * time.Second), CPUs: 1},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if busy.CPUUserMs+busy.CPUSystemMs < 400 {
+		t.Errorf("Expected about 500ms of CPU time for a busy loop, but got %+v", busy)
+	}
+
+	idle, err := exec.Execute(context.Background(), executor.Request{
+		Language: "python",
+		Code:     "import time\ntime.sleep(0.5)",
+		Limits:   language.Limits{Timeout: language.Duration(5 * time.Second)},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if idle.CPUUserMs+idle.CPUSystemMs >= 400 || idle.Timings.RunMs < 500 {
+		t.Errorf("Expected a sleeping program to use little CPU time over at least 500ms, but got %+v", idle)
+	}
+	timings := idle.Timings
+	if timings.CreateMs <= 0 || timings.StartMs <= 0 || timings.SyntaxCheckMs <= 0 || timings.TeardownMs <= 0 {
+		t.Errorf("Expected every phase to be timed, but got %+v", timings)
+	}
+	if sum := timings.CreateMs + timings.StartMs + timings.CopyMs + timings.SyntaxCheckMs + timings.RunMs + timings.TeardownMs; sum > idle.WallTimeMs {
+		t.Errorf("Expected the phases to add up to at most the wall time of %dms, but got %dms", idle.WallTimeMs, sum)
+	}
+}
+
+func TestDockerExecutorStreaming(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	var mu sync.Mutex
+	var stdout, stderr strings.Builder
+	var firstChunk time.Time
+	start := time.Now()
+	result, err := exec.Execute(context.Background(), executor.Request{
+		Language: "python",
+		Code:     "import sys, time\nprint('first')\ntime.sleep(1)\nprint('second')\nprint('oops', file=sys.stderr)",
+		Limits:   language.Limits{Timeout: language.Duration(5 * time.Second)},
+		Output: func(stream executor.Stream, p []byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			if firstChunk.IsZero() {
+				firstChunk = time.Now()
+			}
+			if stream == executor.Stdout {
+				stdout.Write(p)
+			} else {
+				stderr.Write(p)
+			}
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if stdout.String() != result.Stdout || stderr.String() != result.Stderr || result.Stdout != "first\nsecond\n" {
+		t.Errorf("Expected the streamed output to match the result, but got %q and %q for %+v", stdout.String(), stderr.String(), result)
+	}
+	if elapsed := time.Since(start); firstChunk.Sub(start) > elapsed-time.Second/2 {
+		t.Errorf("Expected the first chunk before the program ended, but got it after %s of %s", firstChunk.Sub(start), elapsed)
+	}
+}
+
+func TestDockerExecutorSession(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	session, result, err := exec.StartSession(ctx, executor.Request{
+		Language: "python",
+		Code:     "name = input('Name? ')\nprint('Hello, ' + name)",
+	})
+	if err != nil || result != nil {
+		t.Fatalf("Failed to start session: %v, %+v", err, result)
+	}
+	defer session.Close()
+
+	var output strings.Builder
+	readUntil := func(expected string) {
+		t.Helper()
+		buf := make([]byte, 1024)
+		for !strings.Contains(output.String(), expected) {
+			n, err := session.Read(buf)
+			output.Write(buf[:n])
+			if err != nil {
+				t.Fatalf("Expected %q in the output, but got %q: %v", expected, output.String(), err)
+			}
+		}
+	}
+	readUntil("Name? ")
+	if _, err := session.Write([]byte("Ada\n")); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+	// The terminal echoes the input, as it would to a user
+	readUntil("Hello, Ada")
+	if exitCode, err := session.Wait(); err != nil || exitCode != 0 {
+		t.Errorf("Expected the program to exit with 0, but got %d: %v", exitCode, err)
+	}
+
+	_, result, err = exec.StartSession(ctx, executor.Request{Language: "python", Code: "print('Hello"})
+	if err != nil || result == nil || result.SyntaxCheck.Passed {
+		t.Errorf("Expected a syntax error to be reported as a result, but got %+v: %v", result, err)
+	}
+}
+
+func TestDockerExecutorKernel(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	for _, tc := range []struct {
+		language string
+		cells    []string
+		value    string
+		error    string
+		loop     string
+		// forge writes a line looking like a reply where the kernel's
+		// replies go, and then prints "own"
+		forge string
+	}{
+		{language: "python", cells: []string{"def add(a, b):\n    return a + b", "a = add(2, 3)\nprint(a)\na"}, value: "5", error: "NameError: name 'b' is not defined", loop: "while True: pass", forge: "import sys\nreplies = sys.modules['__main__'].replies\nreplies.write('{\"stdout\": \"forged\"}\\n')\nreplies.flush()\nprint('own')"},
+		{language: "javascript", cells: []string{"function add(a, b) { return a + b }", "let a = add(2, 3); console.log(a); a"}, value: "5", error: "ReferenceError: b is not defined", loop: "while (true) {}", forge: "require('fs').writeSync(1, '{\"stdout\": \"forged\"}\\n'); require('child_process').spawnSync('echo', ['{\"stdout\": \"forged\"}'], {stdio: 'inherit'}); console.log('own')"},
+	} {
+		t.Run(tc.language, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			kernel, err := exec.StartKernel(ctx, executor.Request{
+				Language: tc.language,
+				Limits:   language.Limits{Timeout: language.Duration(2 * time.Second)},
+			})
+			if err != nil {
+				t.Fatalf("Failed to start kernel: %v", err)
+			}
+			defer kernel.Close()
+
+			var result *executor.CellResult
+			for _, cell := range tc.cells {
+				result, err = kernel.Execute(ctx, cell)
+				if err != nil {
+					t.Fatalf("Failed to execute cell: %v", err)
+				}
+			}
+			if result.Stdout != "5\n" || result.Value != tc.value {
+				t.Errorf("Expected state to persist across cells, but got %+v", result)
+			}
+
+			result, err = kernel.Execute(ctx, tc.forge)
+			if err != nil {
+				t.Fatalf("Failed to execute cell: %v", err)
+			}
+			if !strings.HasSuffix(result.Stdout, "own\n") {
+				t.Errorf("Expected the cell's own reply, but got %+v", result)
+			}
+
+			result, err = kernel.Execute(ctx, "b")
+			if err != nil {
+				t.Fatalf("Failed to execute cell: %v", err)
+			}
+			if result.Error != tc.error || result.Stderr == "" || result.KernelExited {
+				t.Errorf("Expected the error to be reported, but got %+v", result)
+			}
+
+			result, err = kernel.Execute(ctx, tc.loop)
+			if err != nil {
+				t.Fatalf("Failed to execute cell: %v", err)
+			}
+			if !result.TimedOut || !result.KernelExited {
+				t.Errorf("Expected the cell to time out and take the kernel down, but got %+v", result)
+			}
+		})
+	}
+}
+
+func TestDockerExecutorKernelReplyLimit(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	kernel, err := exec.StartKernel(ctx, executor.Request{
+		Language: "python",
+		Limits:   language.Limits{Timeout: language.Duration(10 * time.Second), OutputBytes: 1024},
+	})
+	if err != nil {
+		t.Fatalf("Failed to start kernel: %v", err)
+	}
+	defer kernel.Close()
+
+	// Writes to the kernel's replies without ever ending the line
+	result, err := kernel.Execute(ctx, "import sys\nreplies = sys.modules['__main__'].replies\nwhile True:\n    replies.write('x' * 65536)")
+	if err != nil {
+		t.Fatalf("Failed to execute cell: %v", err)
+	}
+	if !result.KernelExited || result.TimedOut {
+		t.Errorf("Expected the flooded kernel to be stopped before the cell times out, but got %+v", result)
+	}
+}
+
+func TestDockerJudge(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	code := "import sys\nnumbers = [int(x) for x in sys.stdin.read().split()]\nif not numbers:\n    while True: pass\nprint(sum(numbers))"
+	report, err := judge.New(exec).Run(context.Background(), executor.Request{Language: "python", Code: code}, []judge.TestCase{
+		{Name: "sum", Stdin: "1 2 3", ExpectedStdout: "6\n"},
+		{Name: "wrong", Stdin: "1 2", ExpectedStdout: "4\n"},
+		{Name: "invalid", Stdin: "x"},
+		{Name: "empty", Limits: language.Limits{Timeout: language.Duration(timeout)}},
+	}, judge.Comparison{Mode: judge.Exact})
+	if err != nil {
+		t.Fatalf("Failed to judge submission: %v", err)
+	}
+	expected := []judge.Verdict{judge.Accepted, judge.WrongAnswer, judge.RuntimeError, judge.TimeLimitExceeded}
+	for i, verdict := range expected {
+		if report.Cases[i].Verdict != verdict {
+			t.Errorf("Expected case %s to be judged %s, but got %+v", report.Cases[i].Name, verdict, report.Cases[i])
+		}
+	}
+}
+
+func TestDockerExecuteBatch(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	code := "import sys\nline = sys.stdin.read().strip()\nif line == 'loop':\n    while True: pass\nprint(int(line) * 2)"
+	batch, err := exec.ExecuteBatch(context.Background(), executor.Request{Language: "python", Code: code}, []executor.Run{
+		{Stdin: "1"},
+		{Stdin: "loop", Limits: language.Limits{Timeout: language.Duration(timeout)}},
+		{Stdin: "3"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to execute batch: %v", err)
+	}
+	if !batch.SyntaxCheck.Passed || len(batch.Runs) != 3 {
+		t.Fatalf("Expected a result per run, but got %+v", batch)
+	}
+	if batch.Runs[0].Stdout != "2\n" || batch.Runs[2].Stdout != "6\n" {
+		t.Errorf("Expected each run to read its own input, but got %q and %q", batch.Runs[0].Stdout, batch.Runs[2].Stdout)
+	}
+	if !batch.Runs[1].TimedOut || batch.Runs[1].Limits.TimeoutMs != timeout.Milliseconds() {
+		t.Errorf("Expected the second run to time out under its own limits, but got %+v", batch.Runs[1])
+	}
+	if batch.Runs[2].ExitCode != 0 {
+		t.Errorf("Expected the run after a timeout to get a new sandbox, but got %+v", batch.Runs[2])
+	}
+
+	// Runs see neither the files nor the processes left behind by the
+	// previous ones, not even as zombies
+	code = "import os, subprocess, sys\nif sys.stdin.read() == 'dirty':\n    open('leftover', 'w').close()\n    open('/tmp/leftover', 'w').close()\n    subprocess.Popen(['sleep', '60'], stdin=subprocess.DEVNULL, stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)\nprint(os.path.exists('leftover'), os.path.exists('/tmp/leftover'), len([p for p in os.listdir('/proc') if p.isdigit()]))"
+	batch, err = exec.ExecuteBatch(context.Background(), executor.Request{Language: "python", Code: code, Limits: language.Limits{Timeout: language.Duration(extendedTimeout)}}, []executor.Run{
+		{Stdin: "clean"},
+		{Stdin: "dirty"},
+		{Stdin: "clean"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to execute batch: %v", err)
+	}
+	if len(batch.Runs) != 3 || batch.Runs[1].TimedOut || !strings.HasPrefix(batch.Runs[0].Stdout, "False False") || batch.Runs[2].Stdout != batch.Runs[0].Stdout {
+		t.Errorf("Expected each run to start from a clean sandbox, but got %+v", batch.Runs)
+	}
+
+	// A run backgrounding processes gets the following runs a new sandbox,
+	// in which they have all of their pids
+	code = "import os, subprocess, sys\nprint(os.path.exists('leftover'), os.path.exists('/tmp/leftover'))\nopen('leftover', 'w').close()\nopen('/tmp/leftover', 'w').close()\nfor _ in range(int(sys.stdin.read())):\n    subprocess.Popen(['sleep', '60'], stdin=subprocess.DEVNULL, stdout=subprocess.DEVNULL, stderr=subprocess.DEVNULL)"
+	batch, err = exec.ExecuteBatch(context.Background(), executor.Request{Language: "python", Code: code, Limits: language.Limits{Timeout: language.Duration(extendedTimeout), Pids: 16}}, []executor.Run{
+		{Stdin: "8"},
+		{Stdin: "12"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to execute batch: %v", err)
+	}
+	if len(batch.Runs) != 2 || batch.Runs[0].ExitCode != 0 {
+		t.Fatalf("Expected the first run to background its processes, but got %+v", batch.Runs)
+	}
+	if batch.Runs[1].ExitCode != 0 || batch.Runs[1].Stdout != "False False\n" {
+		t.Errorf("Expected the second run to start clean with all of its pids, but got %+v", batch.Runs[1])
+	}
+
+	// The build outputs of compiled languages are restored too, including in
+	// the new sandbox following a timeout, which does not compile again
+	code = "#include <stdio.h>\n\nint main(void) {\n\tint n;\n\tscanf(\"%d\", &n);\n\tif (n < 0)\n\t\tfor (;;);\n\tprintf(\"%d\\n\", n * 2);\n\treturn 0;\n}\n"
+	batch, err = exec.ExecuteBatch(context.Background(), executor.Request{Language: "c", Code: code}, []executor.Run{
+		{Stdin: "1"},
+		{Stdin: "2"},
+		{Stdin: "-1", Limits: language.Limits{Timeout: language.Duration(timeout)}},
+		{Stdin: "3"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to execute batch: %v", err)
+	}
+	if len(batch.Runs) != 4 || batch.Runs[0].Stdout != "2\n" || batch.Runs[1].Stdout != "4\n" || !batch.Runs[2].TimedOut || batch.Runs[3].Stdout != "6\n" {
+		t.Errorf("Expected the compiled program to run in each sandbox, but got %+v", batch.Runs)
+	}
+
+	batch, err = exec.ExecuteBatch(context.Background(), executor.Request{Language: "python", Code: "print("}, []executor.Run{{Stdin: "1"}, {Stdin: "2"}})
+	if err != nil {
+		t.Fatalf("Failed to execute batch: %v", err)
+	}
+	if batch.SyntaxCheck.Passed || len(batch.Runs) != 0 {
+		t.Errorf("Expected the submission to be rejected without running, but got %+v", batch)
+	}
+}
+
+func TestDockerExecutorKernelClose(t *testing.T) {
+	exec, err := executor.NewDockerExecutor(language.Default())
+	if err != nil {
+		t.Fatalf("Failed to create Docker executor: %v", err)
+	}
+
+	kernel, err := exec.StartKernel(context.Background(), executor.Request{
+		Language: "python",
+		Limits:   language.Limits{Timeout: language.Duration(time.Minute)},
+	})
+	if err != nil {
+		t.Fatalf("Failed to start kernel: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := kernel.Execute(context.Background(), "import time; time.sleep(60)")
+		done <- err
+	}()
+	time.Sleep(extendedTimeout)
+	kernel.Close()
+	if err := <-done; !errors.Is(err, executor.ErrKernelExited) {
+		t.Errorf("Expected closing the kernel to end the cell with %v, but got %v", executor.ErrKernelExited, err)
+	}
+}
@@ -0,0 +1,75 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/executor/fake"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+func TestFakeExecutor(t *testing.T) {
+	t.Run("DelayBeyondTimeout", func(t *testing.T) {
+		exec := fake.New().Default(fake.Timeout())
+
+		result, err := exec.Execute(context.Background(), executor.Request{
+			Language: "python",
+			Code:     "while True: pass",
+			Limits:   language.Limits{Timeout: language.Duration(10 * time.Millisecond)},
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if !result.TimedOut {
+			t.Error("Expected the run to be reported as timed out")
+		}
+		if result.ExitCode != -1 {
+			t.Errorf("Expected exit code -1, but got %d", result.ExitCode)
+		}
+	})
+
+	t.Run("ContextCancelled", func(t *testing.T) {
+		exec := fake.New().Default(fake.Timeout())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := exec.Execute(ctx, executor.Request{
+			Language: "python",
+			Code:     "while True: pass",
+			Limits:   language.Limits{Timeout: language.Duration(time.Minute)},
+		})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected %v, but got %v", context.DeadlineExceeded, err)
+		}
+	})
+
+	t.Run("ScriptedPerCode", func(t *testing.T) {
+		exec := fake.New().
+			On("print(1)", fake.Output("1\n")).
+			Default(fake.Failure(1, "boom"))
+
+		result, err := exec.Execute(context.Background(), executor.Request{Language: "python", Code: "print(1)"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.Stdout != "1\n" {
+			t.Errorf("Expected output %q, but got %q", "1\n", result.Stdout)
+		}
+
+		result, err = exec.Execute(context.Background(), executor.Request{Language: "python", Code: "print(2)"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.ExitCode != 1 || result.Stderr != "boom" {
+			t.Errorf("Expected the default response, but got %+v", result)
+		}
+
+		if got := len(exec.Requests()); got != 2 {
+			t.Errorf("Expected 2 recorded requests, but got %d", got)
+		}
+	})
+}
@@ -2,20 +2,34 @@ package tests
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/executor/fake"
 	"github.com/isavita/codeexec/internal/handler"
+	"github.com/isavita/codeexec/internal/language"
 )
 
-func TestCodeExecutionHandler(t *testing.T) {
-	// Create a new HTTP request with the code and language in the request body
-	body := map[string]string{
-		"code":     "print('Hello, Python!')",
-		"language": "python",
+func serveExecute(t *testing.T, h http.Handler, body map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	fields := make(map[string]any, len(body))
+	for key, value := range body {
+		fields[key] = value
 	}
+	return serveJSON(t, h, fields)
+}
+
+func serveJSON(t *testing.T, h http.Handler, body map[string]any) *httptest.ResponseRecorder {
+	t.Helper()
 	requestBody, err := json.Marshal(body)
 	if err != nil {
 		t.Fatalf("Failed to marshal request body: %v", err)
@@ -26,14 +40,29 @@ func TestCodeExecutionHandler(t *testing.T) {
 		t.Fatalf("Failed to create request: %v", err)
 	}
 
-	// Create a new HTTP response recorder
 	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	return recorder
+}
 
-	// Create a new code execution handler
-	h := handler.NewCodeExecutionHandler()
+func decodeResult(t *testing.T, recorder *httptest.ResponseRecorder) executor.Result {
+	t.Helper()
+	var response executor.Result
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to unmarshal response body: %v", err)
+	}
+	return response
+}
 
-	// Serve the HTTP request to the handler
-	h.ServeHTTP(recorder, req)
+func TestCodeExecutionHandler(t *testing.T) {
+	// Create a code execution handler backed by a scripted executor
+	exec := fake.New().On("print('Hello, Python!')", fake.Output("Hello, Python!\n"))
+	h := handler.NewCodeExecutionHandler(exec, language.Default())
+
+	recorder := serveExecute(t, h, map[string]string{
+		"code":     "print('Hello, Python!')",
+		"language": "python",
+	})
 
 	// Check the response status code
 	if recorder.Code != http.StatusOK {
@@ -41,14 +70,346 @@ func TestCodeExecutionHandler(t *testing.T) {
 	}
 
 	// Check the response body
+	response := decodeResult(t, recorder)
+	expectedOutput := "Hello, Python!\n"
+	if response.Stdout != expectedOutput {
+		t.Errorf("Expected output %q, but got %q", expectedOutput, response.Stdout)
+	}
+
+	// Check the request forwarded to the executor
+	requests := exec.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 executor request, but got %d", len(requests))
+	}
+	if requests[0].Language != "python" || requests[0].Code != "print('Hello, Python!')" {
+		t.Errorf("Unexpected executor request: %+v", requests[0])
+	}
+	python, _ := language.Default().Lookup("python")
+	if requests[0].Limits != python.Limits {
+		t.Errorf("Expected the default limits %+v, but got %+v", python.Limits, requests[0].Limits)
+	}
+}
+
+func TestCodeExecutionHandlerResults(t *testing.T) {
+	testCases := []struct {
+		name     string
+		response fake.Response
+		check    func(t *testing.T, result executor.Result)
+	}{
+		{
+			name:     "RuntimeError",
+			response: fake.Failure(1, "ZeroDivisionError: division by zero\n"),
+			check: func(t *testing.T, result executor.Result) {
+				if result.ExitCode != 1 {
+					t.Errorf("Expected exit code 1, but got %d", result.ExitCode)
+				}
+				if result.Stderr != "ZeroDivisionError: division by zero\n" {
+					t.Errorf("Unexpected stderr: %q", result.Stderr)
+				}
+			},
+		},
+		{
+			name: "OutputAndWarnings",
+			response: fake.Response{Result: executor.Result{
+				Stdout:      "42\n",
+				Stderr:      "DeprecationWarning: old API\n",
+				SyntaxCheck: executor.SyntaxCheck{Passed: true},
+			}},
+			check: func(t *testing.T, result executor.Result) {
+				if result.Stdout != "42\n" {
+					t.Errorf("Expected stdout to be kept alongside stderr, but got %q", result.Stdout)
+				}
+				if result.Stderr == "" {
+					t.Error("Expected stderr, but got none")
+				}
+			},
+		},
+		{
+			name:     "SyntaxError",
+			response: fake.SyntaxError("SyntaxError: invalid syntax"),
+			check: func(t *testing.T, result executor.Result) {
+				if result.SyntaxCheck.Passed {
+					t.Error("Expected the syntax check to fail, but it passed")
+				}
+				if result.SyntaxCheck.Output != "SyntaxError: invalid syntax" {
+					t.Errorf("Unexpected syntax check output: %q", result.SyntaxCheck.Output)
+				}
+			},
+		},
+		{
+			name:     "CompileError",
+			response: fake.CompileError("main.go:3:2: undefined: fmt.Printn"),
+			check: func(t *testing.T, result executor.Result) {
+				if result.Compile == nil {
+					t.Fatal("Expected a compile result, but got none")
+				}
+				if result.Compile.Succeeded {
+					t.Error("Expected the compilation to fail")
+				}
+				if result.Compile.Stderr != "main.go:3:2: undefined: fmt.Printn" {
+					t.Errorf("Unexpected compiler diagnostics: %q", result.Compile.Stderr)
+				}
+			},
+		},
+		{
+			name:     "OOMKilled",
+			response: fake.OOM(),
+			check: func(t *testing.T, result executor.Result) {
+				if !result.OOMKilled || !result.MemoryExceeded {
+					t.Error("Expected the run to be reported as OOM killed")
+				}
+			},
+		},
+		{
+			name: "TimedOut",
+			response: fake.Response{Result: executor.Result{
+				ExitCode:    -1,
+				TimedOut:    true,
+				SyntaxCheck: executor.SyntaxCheck{Passed: true},
+			}},
+			check: func(t *testing.T, result executor.Result) {
+				if !result.TimedOut {
+					t.Error("Expected the run to be reported as timed out")
+				}
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := handler.NewCodeExecutionHandler(fake.New().Default(tc.response), language.Default())
+
+			recorder := serveExecute(t, h, map[string]string{
+				"code":     "main()",
+				"language": "python",
+			})
+
+			if recorder.Code != http.StatusOK {
+				t.Errorf("Expected status code %d, but got %d", http.StatusOK, recorder.Code)
+			}
+			tc.check(t, decodeResult(t, recorder))
+		})
+	}
+}
+
+func TestCodeExecutionHandlerInfrastructureError(t *testing.T) {
+	exec := fake.New().Default(fake.Error(errors.New("failed to create container: daemon unavailable")))
+	h := handler.NewCodeExecutionHandler(exec, language.Default())
+
+	recorder := serveExecute(t, h, map[string]string{
+		"code":     "print('Hello, Python!')",
+		"language": "python",
+	})
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %d, but got %d", http.StatusInternalServerError, recorder.Code)
+	}
+
 	var response map[string]string
-	err = json.Unmarshal(recorder.Body.Bytes(), &response)
-	if err != nil {
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
 		t.Fatalf("Failed to unmarshal response body: %v", err)
 	}
+	if response["error"] != "failed to create container: daemon unavailable" {
+		t.Errorf("Unexpected error message: %q", response["error"])
+	}
+}
+
+func TestCodeExecutionHandlerRequestCancelled(t *testing.T) {
+	h := handler.NewCodeExecutionHandler(fake.New().Default(fake.Timeout()), language.Default())
+
+	requestBody, err := json.Marshal(map[string]string{
+		"code":     "while True: pass",
+		"language": "python",
+	})
+	if err != nil {
+		t.Fatalf("Failed to marshal request body: %v", err)
+	}
+
+	// Simulate a client that disconnects shortly after sending the request
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", "/execute", bytes.NewReader(requestBody))
+	if err != nil {
+		t.Fatalf("Failed to create request: %v", err)
+	}
+
+	start := time.Now()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the execution to stop with the request, but it took %s", elapsed)
+	}
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code %d, but got %d", http.StatusInternalServerError, recorder.Code)
+	}
+}
+
+func TestCodeExecutionHandlerStdin(t *testing.T) {
+	t.Run("Forwarded", func(t *testing.T) {
+		exec := fake.New()
+		h := handler.NewCodeExecutionHandler(exec, language.Default())
+
+		recorder := serveExecute(t, h, map[string]string{
+			"code":     "print(input())",
+			"language": "python",
+			"stdin":    "3 4\n",
+		})
+
+		if recorder.Code != http.StatusOK {
+			t.Errorf("Expected status code %d, but got %d", http.StatusOK, recorder.Code)
+		}
+		requests := exec.Requests()
+		if len(requests) != 1 || requests[0].Stdin != "3 4\n" {
+			t.Errorf("Expected stdin to be forwarded to the executor, but got %+v", requests)
+		}
+	})
+
+	t.Run("TooLarge", func(t *testing.T) {
+		exec := fake.New()
+		h := handler.NewCodeExecutionHandler(exec, language.Default())
+
+		recorder := serveExecute(t, h, map[string]string{
+			"code":     "print(input())",
+			"language": "python",
+			"stdin":    strings.Repeat("x", 1<<20+1),
+		})
+
+		if recorder.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status code %d, but got %d", http.StatusRequestEntityTooLarge, recorder.Code)
+		}
+		if len(exec.Requests()) != 0 {
+			t.Error("Expected the submission not to be executed")
+		}
+	})
+}
+
+func TestCodeExecutionHandlerProjects(t *testing.T) {
+	t.Run("FilesAndEntrypoint", func(t *testing.T) {
+		exec := fake.New()
+		h := handler.NewCodeExecutionHandler(exec, language.Default())
+
+		recorder := serveJSON(t, h, map[string]any{
+			"language":   "python",
+			"entrypoint": "app/main.py",
+			"files": map[string]string{
+				"app/main.py":    "import helpers",
+				"app/helpers.py": "X = 1",
+			},
+		})
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+		}
+		requests := exec.Requests()
+		if len(requests) != 1 || requests[0].Entrypoint != "app/main.py" || len(requests[0].Files) != 2 {
+			t.Errorf("Expected the project to be forwarded to the executor, but got %+v", requests)
+		}
+	})
 
-	expectedOutput := "Hello, Python!"
-	if response["output"] != expectedOutput {
-		t.Errorf("Expected output %q, but got %q", expectedOutput, response["output"])
+	t.Run("Archive", func(t *testing.T) {
+		exec := fake.New()
+		h := handler.NewCodeExecutionHandler(exec, language.Default())
+		archive := zipArchive(t, map[string]string{"code.py": "print(1)"})
+
+		recorder := serveJSON(t, h, map[string]any{
+			"language": "python",
+			"archive":  base64.StdEncoding.EncodeToString(archive),
+		})
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+		}
+		requests := exec.Requests()
+		if len(requests) != 1 || !bytes.Equal(requests[0].Archive, archive) {
+			t.Error("Expected the decoded archive to be forwarded to the executor")
+		}
+	})
+
+	testCases := []struct {
+		name string
+		body map[string]any
+		err  string
+	}{
+		{
+			name: "InvalidBase64",
+			body: map[string]any{"language": "python", "archive": "not base64!"},
+			err:  "archive is not valid base64",
+		},
+		{
+			name: "EscapingPath",
+			body: map[string]any{"language": "python", "files": map[string]string{"../x.py": "print(1)"}},
+			err:  `invalid request: invalid file path "../x.py"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exec := fake.New()
+			h := handler.NewCodeExecutionHandler(exec, language.Default())
+
+			recorder := serveJSON(t, h, tc.body)
+
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, recorder.Code)
+			}
+			var response map[string]string
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("Failed to unmarshal response body: %v", err)
+			}
+			if response["error"] != tc.err {
+				t.Errorf("Expected error %q, but got %q", tc.err, response["error"])
+			}
+			if len(exec.Requests()) != 0 {
+				t.Error("Expected the submission not to be executed")
+			}
+		})
+	}
+
+	t.Run("RejectedByExecutor", func(t *testing.T) {
+		err := fmt.Errorf("%w: entrypoint main.py not found", executor.ErrInvalidRequest)
+		h := handler.NewCodeExecutionHandler(fake.New().Default(fake.Error(err)), language.Default())
+
+		recorder := serveJSON(t, h, map[string]any{
+			"language": "python",
+			"files":    map[string]string{"helpers.py": "X = 1"},
+		})
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, recorder.Code)
+		}
+	})
+}
+
+func TestCodeExecutionHandlerLimits(t *testing.T) {
+	exec := fake.New().Default(fake.Output(""))
+	h := handler.NewCodeExecutionHandler(exec, language.Default())
+
+	recorder := serveJSON(t, h, map[string]any{
+		"code":     "print(1)",
+		"language": "python",
+		"limits":   map[string]any{"timeout_ms": 10000, "memory_mb": 512, "pids": 100},
+	})
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, recorder.Code, recorder.Body.String())
+	}
+	requests := exec.Requests()
+	limits := requests[0].Limits
+	if time.Duration(limits.Timeout) != 10*time.Second || limits.MemoryMB != 512 || limits.Pids != 100 || limits.CPUs != 0.5 {
+		t.Errorf("Expected the requested limits over the defaults, but got %+v", limits)
+	}
+
+	recorder = serveJSON(t, h, map[string]any{
+		"code":     "print(1)",
+		"language": "python",
+		"limits":   map[string]any{"memory_mb": 4096},
+	})
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, recorder.Code)
+	}
+	if !strings.Contains(recorder.Body.String(), "memory_mb exceeds the maximum of 1024") {
+		t.Errorf("Unexpected error response: %s", recorder.Body.String())
+	}
+	if len(exec.Requests()) != 1 {
+		t.Error("Expected the rejected request not to reach the executor")
 	}
 }
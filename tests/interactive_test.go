@@ -0,0 +1,192 @@
+package tests
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/isavita/codeexec/cmd/api/server"
+	"github.com/isavita/codeexec/internal/auth"
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/executor/fake"
+	"github.com/isavita/codeexec/internal/handler"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// sessionMessage is a message of an interactive session.
+type sessionMessage struct {
+	Type     string           `json:"type"`
+	Data     string           `json:"data,omitempty"`
+	Rows     uint             `json:"rows,omitempty"`
+	Cols     uint             `json:"cols,omitempty"`
+	ExitCode *int             `json:"exit_code,omitempty"`
+	Reason   string           `json:"reason,omitempty"`
+	Result   *executor.Result `json:"result,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// startInteractive serves the API and opens an interactive session with the
+// submission.
+func startInteractive(t *testing.T, exec *fake.Executor, config handler.InteractiveConfig, submission map[string]any) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(server.NewServer(exec, language.Default(), server.WithInteractive(config)))
+	t.Cleanup(srv.Close)
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/interactive", nil)
+	if err != nil {
+		t.Fatalf("Failed to open session: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.WriteJSON(submission); err != nil {
+		t.Fatalf("Failed to send submission: %v", err)
+	}
+	return conn
+}
+
+func readMessage(t *testing.T, conn *websocket.Conn) sessionMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg sessionMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatalf("Failed to read message: %v", err)
+	}
+	return msg
+}
+
+// readOutput reads output messages until they add up to expected.
+func readOutput(t *testing.T, conn *websocket.Conn, expected string) {
+	t.Helper()
+	var output string
+	for len(output) < len(expected) {
+		msg := readMessage(t, conn)
+		if msg.Type != "output" {
+			t.Fatalf("Expected output %q, but got %+v", expected, msg)
+		}
+		output += msg.Data
+	}
+	if output != expected {
+		t.Errorf("Expected output %q, but got %q", expected, output)
+	}
+}
+
+// readExit reads the exit message and checks that the session is closed.
+func readExit(t *testing.T, conn *websocket.Conn, exitCode int, reason string) {
+	t.Helper()
+	msg := readMessage(t, conn)
+	if msg.Type != "exit" || msg.ExitCode == nil || *msg.ExitCode != exitCode || msg.Reason != reason {
+		t.Fatalf("Expected exit code %d for reason %s, but got %+v", exitCode, reason, msg)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("Expected the session to be closed normally, but got %v", err)
+	}
+}
+
+func TestInteractiveSession(t *testing.T) {
+	program := "name = input('Name? ')\nprint('Hello, ' + name)"
+	submission := map[string]any{"code": program, "language": "python"}
+
+	t.Run("Echo", func(t *testing.T) {
+		exec := fake.New().On(program, fake.Output("Name? "))
+		conn := startInteractive(t, exec, handler.InteractiveConfig{}, submission)
+
+		if msg := readMessage(t, conn); msg.Type != "started" {
+			t.Fatalf("Expected the session to start, but got %+v", msg)
+		}
+		readOutput(t, conn, "Name? ")
+		conn.WriteJSON(sessionMessage{Type: "resize", Rows: 40, Cols: 120})
+		conn.WriteJSON(sessionMessage{Type: "input", Data: "Ada\n"})
+		readOutput(t, conn, "Ada\n")
+		conn.WriteJSON(sessionMessage{Type: "input", Data: "\x04"})
+		readExit(t, conn, 0, "exited")
+
+		sessions := exec.Sessions()
+		if len(sessions) != 1 || len(sessions[0].Sizes()) != 1 || sessions[0].Sizes()[0] != [2]uint{40, 120} {
+			t.Errorf("Expected the terminal to be resized to 40x120, but got %+v", sessions[0].Sizes())
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		conn := startInteractive(t, fake.New(), handler.InteractiveConfig{}, submission)
+		readMessage(t, conn)
+		conn.WriteJSON(sessionMessage{Type: "close"})
+		readExit(t, conn, -1, "closed")
+	})
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		conn := startInteractive(t, fake.New(), handler.InteractiveConfig{IdleTimeout: 50 * time.Millisecond}, submission)
+		readMessage(t, conn)
+		readExit(t, conn, -1, "idle_timeout")
+	})
+
+	t.Run("MaxDuration", func(t *testing.T) {
+		conn := startInteractive(t, fake.New(), handler.InteractiveConfig{MaxDuration: 100 * time.Millisecond}, submission)
+		readMessage(t, conn)
+		// Activity does not extend the session past its maximum duration
+		for range 3 {
+			conn.WriteJSON(sessionMessage{Type: "input", Data: "."})
+			time.Sleep(20 * time.Millisecond)
+		}
+		for {
+			msg := readMessage(t, conn)
+			if msg.Type == "exit" {
+				if msg.Reason != "max_duration" {
+					t.Errorf("Expected the session to end for reason max_duration, but got %+v", msg)
+				}
+				break
+			}
+		}
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		exec := fake.New().Default(fake.SyntaxError("SyntaxError: invalid syntax"))
+		conn := startInteractive(t, exec, handler.InteractiveConfig{}, submission)
+		msg := readMessage(t, conn)
+		if msg.Type != "rejected" || msg.Result == nil || msg.Result.SyntaxCheck.Passed {
+			t.Errorf("Expected the submission to be rejected with its result, but got %+v", msg)
+		}
+	})
+
+	t.Run("InvalidRequest", func(t *testing.T) {
+		exec := fake.New()
+		conn := startInteractive(t, exec, handler.InteractiveConfig{}, map[string]any{"code": program})
+		if msg := readMessage(t, conn); msg.Type != "error" || msg.Error != "language not specified" {
+			t.Errorf("Expected an error, but got %+v", msg)
+		}
+		if len(exec.Sessions()) != 0 {
+			t.Error("Expected no session to start")
+		}
+	})
+}
+
+func TestInteractiveSessionAuth(t *testing.T) {
+	keys, err := auth.Parse([]byte(keysConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse keys: %v", err)
+	}
+	srv := httptest.NewServer(server.NewServer(fake.New(), language.Default(), server.WithAPIKeys(keys)))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/api/interactive"
+
+	_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if err == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected a session without a key to be rejected, but got %v", err)
+	}
+
+	// Browsers cannot set headers on WebSocket handshakes
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?api_key=editor-key", nil)
+	if err != nil {
+		t.Fatalf("Expected the key in the query to be accepted, but got %v", err)
+	}
+	conn.Close()
+
+	header := http.Header{"X-Api-Key": []string{"editor-key"}}
+	conn, _, err = websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		t.Fatalf("Expected the key in the header to be accepted, but got %v", err)
+	}
+	conn.Close()
+}
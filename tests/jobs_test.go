@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/isavita/codeexec/cmd/api/server"
+	"github.com/isavita/codeexec/internal/auth"
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/executor/fake"
+	"github.com/isavita/codeexec/internal/jobs"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// waitForJob polls a job until it has finished.
+func waitForJob(t *testing.T, queue *jobs.Queue, id, owner string) jobs.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := queue.Get(id, owner)
+		if err != nil {
+			t.Fatalf("Failed to get job %s: %v", id, err)
+		}
+		if job.Status.Finished() {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("Job %s did not finish in time", id)
+	return jobs.Job{}
+}
+
+func TestJobQueue(t *testing.T) {
+	exec := fake.New().
+		On("print('hi')", fake.Output("hi\n")).
+		On("broken", fake.Error(errors.New("daemon unavailable"))).
+		On("while True: pass", fake.Timeout())
+	request := func(code string) executor.Request {
+		return executor.Request{Language: "python", Code: code, Limits: language.Limits{Timeout: language.Duration(time.Hour)}}
+	}
+
+	t.Run("Succeeded", func(t *testing.T) {
+		queue := jobs.New(exec, jobs.Config{Workers: 1})
+		defer queue.Close()
+
+		job, err := queue.Submit(request("print('hi')"), "", "")
+		if err != nil {
+			t.Fatalf("Failed to submit job: %v", err)
+		}
+		if job.ID == "" || job.Status != jobs.StatusQueued {
+			t.Errorf("Expected a queued job with an ID, but got %+v", job)
+		}
+		job = waitForJob(t, queue, job.ID, "")
+		if job.Status != jobs.StatusSucceeded || job.Result == nil || job.Result.Stdout != "hi\n" {
+			t.Errorf("Expected the job to succeed with the program's output, but got %+v", job)
+		}
+		if job.StartedAt == nil || job.FinishedAt == nil {
+			t.Errorf("Expected the job to be timestamped, but got %+v", job)
+		}
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		queue := jobs.New(exec, jobs.Config{Workers: 1})
+		defer queue.Close()
+
+		job, _ := queue.Submit(request("broken"), "", "")
+		job = waitForJob(t, queue, job.ID, "")
+		if job.Status != jobs.StatusFailed || job.Error != "daemon unavailable" {
+			t.Errorf("Expected the job to fail with the executor's error, but got %+v", job)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		queue := jobs.New(exec, jobs.Config{Workers: 1})
+		defer queue.Close()
+
+		running, _ := queue.Submit(request("while True: pass"), "", "")
+		queued, _ := queue.Submit(request("print('hi')"), "", "")
+
+		// The queued job is cancelled right away
+		job, err := queue.Cancel(queued.ID, "")
+		if err != nil || job.Status != jobs.StatusCancelled {
+			t.Errorf("Expected the queued job to be cancelled, but got %+v, %v", job, err)
+		}
+
+		for {
+			job, _ := queue.Get(running.ID, "")
+			if job.Status == jobs.StatusRunning {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if _, err := queue.Cancel(running.ID, ""); err != nil {
+			t.Fatalf("Failed to cancel the running job: %v", err)
+		}
+		if job := waitForJob(t, queue, running.ID, ""); job.Status != jobs.StatusCancelled {
+			t.Errorf("Expected the running job to be cancelled, but got %+v", job)
+		}
+		if _, err := queue.Cancel(running.ID, ""); !errors.Is(err, jobs.ErrFinished) {
+			t.Errorf("Expected %v, but got %v", jobs.ErrFinished, err)
+		}
+	})
+
+	t.Run("QueueFull", func(t *testing.T) {
+		queue := jobs.New(exec, jobs.Config{Workers: 1, QueueSize: 1})
+		defer queue.Close()
+
+		var err error
+		for range 3 {
+			if _, err = queue.Submit(request("while True: pass"), "", ""); err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, jobs.ErrQueueFull) {
+			t.Errorf("Expected %v, but got %v", jobs.ErrQueueFull, err)
+		}
+	})
+
+	t.Run("Owner", func(t *testing.T) {
+		queue := jobs.New(exec, jobs.Config{Workers: 1})
+		defer queue.Close()
+
+		job, _ := queue.Submit(request("print('hi')"), "editor", "")
+		if _, err := queue.Get(job.ID, "notebooks"); !errors.Is(err, jobs.ErrNotFound) {
+			t.Errorf("Expected another owner's job not to be found, but got %v", err)
+		}
+		if _, err := queue.Cancel(job.ID, "notebooks"); !errors.Is(err, jobs.ErrNotFound) {
+			t.Errorf("Expected another owner's job not to be cancellable, but got %v", err)
+		}
+	})
+}
+
+func TestJobsEndpoints(t *testing.T) {
+	keys, err := auth.Parse([]byte(keysConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse keys: %v", err)
+	}
+	exec := fake.New().On("print('hi')", fake.Output("hi\n"))
+	queue := jobs.New(exec, jobs.Config{Workers: 1})
+	defer queue.Close()
+	srv := server.NewServer(exec, language.Default(), server.WithAPIKeys(keys), server.WithJobs(queue))
+
+	serve := func(method, path, apiKey string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var reader bytes.Reader
+		if body != nil {
+			data, _ := json.Marshal(body)
+			reader.Reset(data)
+		}
+		req := httptest.NewRequest(method, path, &reader)
+		req.Header.Set("X-Api-Key", apiKey)
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := serve("POST", "/api/jobs", "editor-key", map[string]any{"code": "print('hi')", "language": "python"})
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusAccepted, recorder.Code, recorder.Body)
+	}
+	var job jobs.Job
+	if err := json.Unmarshal(recorder.Body.Bytes(), &job); err != nil {
+		t.Fatalf("Failed to decode job: %v", err)
+	}
+	if location := recorder.Header().Get("Location"); location != "/api/jobs/"+job.ID {
+		t.Errorf("Expected the job's location, but got %q", location)
+	}
+
+	waitForJob(t, queue, job.ID, "editor")
+	recorder = serve("GET", "/api/jobs/"+job.ID, "editor-key", nil)
+	if err := json.Unmarshal(recorder.Body.Bytes(), &job); err != nil {
+		t.Fatalf("Failed to decode job: %v", err)
+	}
+	if recorder.Code != http.StatusOK || job.Status != jobs.StatusSucceeded || job.Result.Stdout != "hi\n" {
+		t.Errorf("Expected the finished job, but got status code %d and %+v", recorder.Code, job)
+	}
+
+	if recorder := serve("GET", "/api/jobs/"+job.ID, "notebooks-key", nil); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected another key's job not to be found, but got status code %d", recorder.Code)
+	}
+	if recorder := serve("DELETE", "/api/jobs/"+job.ID, "editor-key", nil); recorder.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d for cancelling a finished job, but got %d", http.StatusConflict, recorder.Code)
+	}
+	if recorder := serve("POST", "/api/jobs", "editor-key", map[string]any{"code": "print('hi')"}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected invalid submissions to be rejected, but got status code %d", recorder.Code)
+	}
+	if recorder := serve("GET", "/api/jobs/"+job.ID, "unknown-key", nil); recorder.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code %d for an unknown key, but got %d", http.StatusUnauthorized, recorder.Code)
+	}
+}
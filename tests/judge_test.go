@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/executor/fake"
+	"github.com/isavita/codeexec/internal/handler"
+	"github.com/isavita/codeexec/internal/judge"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// echoExecutor runs programs that print their input, except for the inputs
+// "loop", "oom" and "crash", and checkers that accept outputs equal to the
+// expected output.
+type echoExecutor struct{}
+
+func (echoExecutor) Execute(ctx context.Context, req executor.Request) (*executor.Result, error) {
+	result := &executor.Result{SyntaxCheck: executor.SyntaxCheck{Passed: true}, Limits: executor.Limits{TimeoutMs: int64(req.Limits.Timeout) / 1e6}}
+	if output, ok := req.Files[judge.OutputFile]; ok {
+		if output != req.Files[judge.ExpectedFile] {
+			result.ExitCode = 1
+			result.Stdout = "outputs differ\n"
+		}
+		return result, nil
+	}
+	switch req.Stdin {
+	case "loop":
+		result.TimedOut = true
+		result.ExitCode = -1
+	case "oom":
+		result.OOMKilled = true
+		result.MemoryExceeded = true
+		result.ExitCode = 137
+	case "crash":
+		result.Stderr = "Traceback (most recent call last):\n"
+		result.ExitCode = 1
+	default:
+		result.Stdout = req.Stdin
+	}
+	return result, nil
+}
+
+func TestJudge(t *testing.T) {
+	request := executor.Request{Language: "python", Code: "print(input())"}
+	run := func(t *testing.T, comparison judge.Comparison, cases ...judge.TestCase) *judge.Report {
+		t.Helper()
+		report, err := judge.New(echoExecutor{}).Run(context.Background(), request, cases, comparison)
+		if err != nil {
+			t.Fatalf("Failed to judge submission: %v", err)
+		}
+		return report
+	}
+
+	t.Run("Comparisons", func(t *testing.T) {
+		testCases := []struct {
+			name       string
+			comparison judge.Comparison
+			output     string
+			expected   string
+			verdict    judge.Verdict
+		}{
+			{name: "Exact", comparison: judge.Comparison{Mode: judge.Exact}, output: "1 2\n", expected: "1 2\n", verdict: judge.Accepted},
+			{name: "ExactSpacing", comparison: judge.Comparison{Mode: judge.Exact}, output: "1  2\n", expected: "1 2\n", verdict: judge.WrongAnswer},
+			{name: "ExactTrailingNewline", comparison: judge.Comparison{Mode: judge.Exact}, output: "1 2", expected: "1 2\n", verdict: judge.WrongAnswer},
+			{name: "Whitespace", comparison: judge.Comparison{Mode: judge.Whitespace}, output: "1\t 2", expected: "1 2\n", verdict: judge.Accepted},
+			{name: "WhitespaceMissingToken", comparison: judge.Comparison{Mode: judge.Whitespace}, output: "1", expected: "1 2\n", verdict: judge.WrongAnswer},
+			{name: "Float", comparison: judge.Comparison{Mode: judge.Float}, output: "yes 0.3333337\n", expected: "yes 0.333333\n", verdict: judge.Accepted},
+			{name: "FloatRelative", comparison: judge.Comparison{Mode: judge.Float, Tolerance: 1e-3}, output: "1000500", expected: "1000000", verdict: judge.Accepted},
+			{name: "FloatOutsideTolerance", comparison: judge.Comparison{Mode: judge.Float}, output: "0.334", expected: "0.333333", verdict: judge.WrongAnswer},
+			{name: "FloatWords", comparison: judge.Comparison{Mode: judge.Float}, output: "no 0.333333", expected: "yes 0.333333", verdict: judge.WrongAnswer},
+			{name: "Checker", comparison: judge.Comparison{Mode: judge.Checker, Checker: &executor.Request{Language: "python", Code: "checker"}}, output: "42\n", expected: "42\n", verdict: judge.Accepted},
+			{name: "CheckerRejects", comparison: judge.Comparison{Mode: judge.Checker, Checker: &executor.Request{Language: "python", Code: "checker"}}, output: "41\n", expected: "42\n", verdict: judge.WrongAnswer},
+		}
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				report := run(t, tc.comparison, judge.TestCase{Stdin: tc.output, ExpectedStdout: tc.expected})
+				if report.Cases[0].Verdict != tc.verdict {
+					t.Errorf("Expected verdict %s, but got %+v", tc.verdict, report.Cases[0])
+				}
+				if tc.verdict != judge.Accepted && report.Cases[0].Message == "" {
+					t.Error("Expected the verdict to be explained")
+				}
+			})
+		}
+	})
+
+	t.Run("Verdicts", func(t *testing.T) {
+		report := run(t, judge.Comparison{},
+			judge.TestCase{Name: "ok", Stdin: "1\n", ExpectedStdout: "1\n"},
+			judge.TestCase{Name: "wrong", Stdin: "1\n", ExpectedStdout: "2\n"},
+			judge.TestCase{Name: "crash", Stdin: "crash"},
+			judge.TestCase{Name: "loop", Stdin: "loop"},
+			judge.TestCase{Name: "oom", Stdin: "oom"},
+		)
+		expected := []judge.Verdict{judge.Accepted, judge.WrongAnswer, judge.RuntimeError, judge.TimeLimitExceeded, judge.MemoryLimitExceeded}
+		for i, verdict := range expected {
+			if report.Cases[i].Verdict != verdict {
+				t.Errorf("Expected case %s to be judged %s, but got %+v", report.Cases[i].Name, verdict, report.Cases[i])
+			}
+		}
+		if report.Verdict != judge.WrongAnswer || report.Passed != 1 || report.Total != 5 {
+			t.Errorf("Expected 1 of 5 cases to pass with the first failure's verdict, but got %s with %d of %d", report.Verdict, report.Passed, report.Total)
+		}
+	})
+
+	t.Run("CaseLimits", func(t *testing.T) {
+		limits := language.Limits{Timeout: language.Duration(5e9)}
+		report := run(t, judge.Comparison{}, judge.TestCase{Stdin: "1", ExpectedStdout: "1", Limits: limits})
+		if report.Cases[0].Limits.TimeoutMs != 5000 {
+			t.Errorf("Expected the case to run with its own limits, but got %+v", report.Cases[0].Limits)
+		}
+	})
+
+	t.Run("CompileError", func(t *testing.T) {
+		exec := fake.New().Default(fake.CompileError("main.c:1:1: error: expected ';'"))
+		report, err := judge.New(exec).Run(context.Background(), request, []judge.TestCase{{Stdin: "1"}, {Stdin: "2"}}, judge.Comparison{})
+		if err != nil {
+			t.Fatalf("Failed to judge submission: %v", err)
+		}
+		if report.Verdict != judge.CompileError || report.Compile == nil || report.SyntaxCheck.Passed {
+			t.Errorf("Expected a compile error with the compiler's output, but got %+v", report)
+		}
+		for _, c := range report.Cases {
+			if c.Verdict != judge.CompileError {
+				t.Errorf("Expected every case to fail to compile, but got %+v", c)
+			}
+		}
+		if len(exec.Requests()) != 1 {
+			t.Errorf("Expected the submission to be compiled once, but it ran %d times", len(exec.Requests()))
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		exec := fake.New()
+		limits := language.Limits{Timeout: language.Duration(5e9)}
+		cases := []judge.TestCase{{Stdin: "1"}, {Stdin: "2", Limits: limits}, {Stdin: "3"}}
+		report, err := judge.New(exec).Run(context.Background(), request, cases, judge.Comparison{})
+		if err != nil {
+			t.Fatalf("Failed to judge submission: %v", err)
+		}
+		if exec.Batches() != 1 {
+			t.Errorf("Expected the cases to run as one batch, but got %d batches", exec.Batches())
+		}
+		requests := exec.Requests()
+		if len(requests) != 3 || requests[1].Stdin != "2" || requests[1].Limits.Timeout != limits.Timeout {
+			t.Errorf("Expected a run per case with the case's input and limits, but got %+v", requests)
+		}
+		if report.Total != 3 || len(report.Cases) != 3 {
+			t.Errorf("Expected a result per case, but got %+v", report)
+		}
+	})
+}
+
+func TestJudgeHandler(t *testing.T) {
+	h := handler.NewJudgeHandler(echoExecutor{}, language.Default())
+
+	t.Run("Report", func(t *testing.T) {
+		recorder := serveJSON(t, h, map[string]any{
+			"code":     "print(input())",
+			"language": "python",
+			"test_cases": []map[string]any{
+				{"name": "small", "stdin": "1 2\n", "expected_stdout": "1 2"},
+				{"name": "large", "stdin": "3 4\n", "expected_stdout": "3 5", "limits": map[string]any{"timeout_ms": 10000}},
+			},
+			"comparison": map[string]any{"mode": "whitespace"},
+		})
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status code %d, but got %d: %s", http.StatusOK, recorder.Code, recorder.Body)
+		}
+		var report judge.Report
+		if err := json.Unmarshal(recorder.Body.Bytes(), &report); err != nil {
+			t.Fatalf("Failed to decode report: %v", err)
+		}
+		if report.Verdict != judge.WrongAnswer || len(report.Cases) != 2 || report.Cases[0].Verdict != judge.Accepted {
+			t.Errorf("Expected the second case to fail, but got %+v", report)
+		}
+		if report.Cases[1].Limits.TimeoutMs != 10000 || report.Cases[0].Limits.TimeoutMs == 10000 {
+			t.Errorf("Expected only the second case to run with a longer timeout, but got %+v and %+v", report.Cases[0].Limits, report.Cases[1].Limits)
+		}
+	})
+
+	invalid := []struct {
+		name string
+		body map[string]any
+		err  string
+	}{
+		{name: "NoTestCases", body: map[string]any{"code": "print(1)", "language": "python"}, err: "test_cases not provided"},
+		{name: "Stdin", body: map[string]any{"code": "print(1)", "language": "python", "stdin": "1", "test_cases": []map[string]any{{}}}, err: "stdin is given by the test cases"},
+		{name: "UnknownMode", body: map[string]any{"code": "print(1)", "language": "python", "test_cases": []map[string]any{{}}, "comparison": map[string]any{"mode": "fuzzy"}}, err: "unknown comparison mode"},
+		{name: "NoChecker", body: map[string]any{"code": "print(1)", "language": "python", "test_cases": []map[string]any{{}}, "comparison": map[string]any{"mode": "checker"}}, err: "checker not provided"},
+		{name: "InvalidChecker", body: map[string]any{"code": "print(1)", "language": "python", "test_cases": []map[string]any{{}}, "comparison": map[string]any{"mode": "checker", "checker": map[string]any{"code": "exit(0)"}}}, err: "checker: language not specified"},
+		{name: "CaseLimitsTooHigh", body: map[string]any{"code": "print(1)", "language": "python", "test_cases": []map[string]any{{"limits": map[string]any{"timeout_ms": 3600000}}}}, err: "test case 1"},
+	}
+	for _, tc := range invalid {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := serveJSON(t, h, tc.body)
+			if recorder.Code != http.StatusBadRequest || !strings.Contains(recorder.Body.String(), tc.err) {
+				t.Errorf("Expected status code %d with %q, but got %d: %s", http.StatusBadRequest, tc.err, recorder.Code, recorder.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,328 @@
+package tests
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isavita/codeexec/internal/executor/fake"
+	"github.com/isavita/codeexec/internal/handler"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+const rubyConfig = `{
+  "languages": [
+    {
+      "name": "ruby",
+      "version": "3.3",
+      "image": "ruby-exec",
+      "file_name": "code.rb",
+      "run": ["ruby", "code.rb"],
+      "syntax_check": ["ruby", "-c", "code.rb"],
+      "limits": {"timeout": "3s", "memory_mb": 128, "cpus": 1}
+    }
+  ]
+}`
+
+func TestDefaultLanguages(t *testing.T) {
+	registry := language.Default()
+
+	names := registry.Names()
+	expected := "c,cpp,go,java,javascript,python,rust"
+	if strings.Join(names, ",") != expected {
+		t.Errorf("Expected the bundled languages %s, but got %v", expected, names)
+	}
+
+	python, ok := registry.Lookup("python")
+	if !ok {
+		t.Fatal("Expected python to be configured")
+	}
+	if python.Image != "python-exec" || python.FileName != "code.py" {
+		t.Errorf("Unexpected python config: %+v", python)
+	}
+	if time.Duration(python.Limits.Timeout) != 5*time.Second {
+		t.Errorf("Expected a 5s default timeout, but got %s", time.Duration(python.Limits.Timeout))
+	}
+	if len(python.Compile) != 0 {
+		t.Errorf("Expected python to be interpreted, but got compile command %v", python.Compile)
+	}
+	for _, stderr := range []string{
+		"Traceback (most recent call last):\nMemoryError\n",
+		"Traceback (most recent call last):\nnumpy.core._exceptions._ArrayMemoryError: Unable to allocate 9.54 PiB\n",
+	} {
+		if !python.OutOfMemory().MatchString(stderr) {
+			t.Errorf("Expected python's oom_pattern to match %q", stderr)
+		}
+	}
+
+	for _, name := range []string{"go", "c", "cpp", "rust", "java"} {
+		lang, ok := registry.Lookup(name)
+		if !ok {
+			t.Errorf("Expected %s to be configured", name)
+			continue
+		}
+		if len(lang.Compile) == 0 || len(lang.SyntaxCheck) != 0 {
+			t.Errorf("Expected %s to compile instead of syntax checking, but got %+v", name, lang)
+		}
+		if lang.CompileLimits.Timeout <= lang.Limits.Timeout {
+			t.Errorf("Expected %s to allow more time for compiling than running", name)
+		}
+	}
+}
+
+func TestLoadLanguages(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "languages.json")
+	if err := os.WriteFile(filename, []byte(rubyConfig), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	registry, err := language.Load(filename)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	ruby, ok := registry.Lookup("ruby")
+	if !ok {
+		t.Fatal("Expected ruby to be configured")
+	}
+	if ruby.Limits.MemoryMB != 128 || time.Duration(ruby.Limits.Timeout) != 3*time.Second {
+		t.Errorf("Unexpected ruby limits: %+v", ruby.Limits)
+	}
+	if _, ok := registry.Lookup("python"); ok {
+		t.Error("Expected only the configured languages to be available")
+	}
+}
+
+func TestParseLanguagesValidation(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name:   "NoLanguages",
+			config: `{"languages": []}`,
+			err:    "no languages configured",
+		},
+		{
+			name:   "MissingImage",
+			config: `{"languages": [{"name": "go", "file_name": "main.go", "run": ["go", "run", "main.go"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1}}]}`,
+			err:    "image not set",
+		},
+		{
+			name:   "FileNameWithPath",
+			config: `{"languages": [{"name": "go", "image": "go-exec", "file_name": "../main.go", "run": ["go", "run", "main.go"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1}}]}`,
+			err:    "file_name must be a plain file name",
+		},
+		{
+			name:   "InvalidTimeout",
+			config: `{"languages": [{"name": "go", "image": "go-exec", "file_name": "main.go", "run": ["go", "run", "main.go"], "limits": {"timeout": "soon", "memory_mb": 64, "cpus": 1}}]}`,
+			err:    "invalid duration",
+		},
+		{
+			name:   "CompileWithoutLimits",
+			config: `{"languages": [{"name": "go", "image": "go-exec", "file_name": "main.go", "compile": ["go", "build", "main.go"], "run": ["./main"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1}}]}`,
+			err:    "compile_limits.timeout must be positive",
+		},
+		{
+			name:   "CompileAndSyntaxCheck",
+			config: `{"languages": [{"name": "go", "image": "go-exec", "file_name": "main.go", "compile": ["go", "build", "main.go"], "syntax_check": ["gofmt", "-e", "main.go"], "run": ["./main"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1}, "compile_limits": {"timeout": "30s", "memory_mb": 512, "cpus": 1}}]}`,
+			err:    "mutually exclusive",
+		},
+		{
+			name:   "MaxBelowDefault",
+			config: `{"languages": [{"name": "go", "image": "go-exec", "file_name": "main.go", "run": ["go", "run", "main.go"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1}, "max_limits": {"memory_mb": 32}}]}`,
+			err:    "max_limits must not be below limits",
+		},
+		{
+			name:   "NegativePids",
+			config: `{"languages": [{"name": "go", "image": "go-exec", "file_name": "main.go", "run": ["go", "run", "main.go"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1, "pids": -1}}]}`,
+			err:    "limits.pids must be positive",
+		},
+		{
+			name:   "UnknownSeccompProfile",
+			config: `{"languages": [{"name": "go", "image": "go-exec", "file_name": "main.go", "run": ["go", "run", "main.go"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1}, "security": {"seccomp": "strict"}}]}`,
+			err:    "unknown seccomp profile",
+		},
+		{
+			name:   "UnknownUlimit",
+			config: `{"languages": [{"name": "go", "image": "go-exec", "file_name": "main.go", "run": ["go", "run", "main.go"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1}, "security": {"ulimits": {"files": 10}}}]}`,
+			err:    "unknown ulimit",
+		},
+		{
+			name:   "InvalidEnv",
+			config: `{"languages": [{"name": "go", "image": "go-exec", "file_name": "main.go", "run": ["go", "run", "main.go"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1}, "env": ["GOCACHE"]}]}`,
+			err:    "expected NAME=value",
+		},
+		{
+			name:   "InvalidOOMPattern",
+			config: `{"languages": [{"name": "go", "image": "go-exec", "file_name": "main.go", "run": ["go", "run", "main.go"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1}, "oom_pattern": "(out of memory"}]}`,
+			err:    "invalid oom_pattern",
+		},
+		{
+			name:   "UnknownKernel",
+			config: `{"languages": [{"name": "go", "image": "go-exec", "file_name": "main.go", "run": ["go", "run", "main.go"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1}, "kernel": "gophernotes"}]}`,
+			err:    "unknown kernel",
+		},
+		{
+			name:   "Duplicate",
+			config: "{\"languages\": [" + strings.Repeat(`{"name": "go", "image": "go-exec", "file_name": "main.go", "run": ["go", "run", "main.go"], "limits": {"timeout": "5s", "memory_mb": 64, "cpus": 1}},`, 2) + "{}]}",
+			err:    "configured more than once",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := language.Parse([]byte(tc.config))
+			if err == nil {
+				t.Fatalf("Expected an error containing '%s', but got nil", tc.err)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Expected an error containing '%s', but got: %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestSecurityDefaults(t *testing.T) {
+	registry, err := language.Parse([]byte(`{"languages": [
+		{"name": "ruby", "image": "ruby-exec", "file_name": "code.rb", "run": ["ruby", "code.rb"], "limits": {"timeout": "3s", "memory_mb": 128, "cpus": 1}},
+		{"name": "perl", "image": "perl-exec", "file_name": "code.pl", "run": ["perl", "code.pl"], "limits": {"timeout": "3s", "memory_mb": 128, "cpus": 1},
+		 "security": {"user": "1000:1000", "work_dir_mb": 16, "tmp_mb": 8, "seccomp": "docker", "ulimits": {"nofile": 64}}}
+	]}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+
+	ruby, _ := registry.Lookup("ruby")
+	security := ruby.Security
+	if security.User != "65534:65534" || security.WritableRoot || security.AllowNewPrivileges || len(security.CapAdd) != 0 {
+		t.Errorf("Expected ruby to run unprivileged as nobody, but got %+v", security)
+	}
+	if security.WorkDirMB != 64 || security.TmpMB != 64 || security.Seccomp != language.SeccompBundled {
+		t.Errorf("Expected the default tmpfs sizes and bundled seccomp profile, but got %+v", security)
+	}
+	if security.Ulimits["nofile"] != 1024 || security.Ulimits["core"] != 0 || security.Ulimits["fsize"] != 64<<20 {
+		t.Errorf("Unexpected default ulimits: %v", security.Ulimits)
+	}
+	if ruby.Limits.Pids <= 0 {
+		t.Errorf("Expected a default pids limit, but got %d", ruby.Limits.Pids)
+	}
+
+	perl, _ := registry.Lookup("perl")
+	security = perl.Security
+	if security.User != "1000:1000" || security.WorkDirMB != 16 || security.TmpMB != 8 || security.Seccomp != language.SeccompDocker {
+		t.Errorf("Expected the configured profile to be kept, but got %+v", security)
+	}
+	if security.Ulimits["nofile"] != 64 || security.Ulimits["fsize"] != 16<<20 {
+		t.Errorf("Expected configured ulimits to override the defaults, but got %v", security.Ulimits)
+	}
+}
+
+func TestHandlerUsesLanguageRegistry(t *testing.T) {
+	registry, err := language.Parse([]byte(rubyConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	exec := fake.New()
+	h := handler.NewCodeExecutionHandler(exec, registry)
+
+	recorder := serveExecute(t, h, map[string]string{"code": "puts 1", "language": "ruby"})
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, recorder.Code)
+	}
+	requests := exec.Requests()
+	if len(requests) != 1 || time.Duration(requests[0].Limits.Timeout) != 3*time.Second {
+		t.Errorf("Expected one request with the ruby default timeout, but got %+v", requests)
+	}
+
+	recorder = serveExecute(t, h, map[string]string{"code": "print(1)", "language": "python"})
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an unconfigured language, but got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestResolveLimits(t *testing.T) {
+	registry, err := language.Parse([]byte(`{"languages": [{"name": "ruby", "image": "ruby-exec", "file_name": "code.rb", "run": ["ruby", "code.rb"],
+		"limits": {"timeout": "3s", "memory_mb": 128, "cpus": 1},
+		"max_limits": {"timeout": "30s", "memory_mb": 1024, "pids": 256, "output_bytes": 4194304}}]}`))
+	if err != nil {
+		t.Fatalf("Failed to parse config: %v", err)
+	}
+	ruby, _ := registry.Lookup("ruby")
+
+	defaults := language.Limits{Timeout: language.Duration(3 * time.Second), MemoryMB: 128, CPUs: 1, Pids: 64, OutputBytes: 1 << 20}
+	if ruby.Limits != defaults {
+		t.Fatalf("Expected the default pids and output limits to be filled in, but got %+v", ruby.Limits)
+	}
+
+	testCases := []struct {
+		name      string
+		requested language.Limits
+		ceiling   language.Limits
+		expected  language.Limits
+		err       string
+	}{
+		{
+			name:     "Defaults",
+			expected: defaults,
+		},
+		{
+			name:      "WithinMaximum",
+			requested: language.Limits{MemoryMB: 512, Pids: 128},
+			expected:  language.Limits{Timeout: defaults.Timeout, MemoryMB: 512, CPUs: 1, Pids: 128, OutputBytes: 1 << 20},
+		},
+		{
+			name:      "BelowDefault",
+			requested: language.Limits{Timeout: language.Duration(time.Second), MemoryMB: 16},
+			expected:  language.Limits{Timeout: language.Duration(time.Second), MemoryMB: 16, CPUs: 1, Pids: 64, OutputBytes: 1 << 20},
+		},
+		{
+			name:      "AboveLanguageMaximum",
+			requested: language.Limits{MemoryMB: 2048},
+			err:       "memory_mb exceeds the maximum of 1024",
+		},
+		{
+			name:      "UnsetMaximumDefaultsToLimit",
+			requested: language.Limits{CPUs: 2},
+			err:       "cpus exceeds the maximum of 1",
+		},
+		{
+			name:      "AboveCeiling",
+			requested: language.Limits{Timeout: language.Duration(20 * time.Second)},
+			ceiling:   language.Limits{Timeout: language.Duration(10 * time.Second)},
+			err:       "timeout exceeds the maximum of 10s",
+		},
+		{
+			name:     "CeilingBelowDefault",
+			ceiling:  language.Limits{MemoryMB: 64},
+			expected: defaults,
+		},
+		{
+			name:      "Negative",
+			requested: language.Limits{OutputBytes: -1},
+			err:       "limits must not be negative",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			limits, err := ruby.ResolveLimits(tc.requested, tc.ceiling)
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("Expected an error containing '%s', but got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if limits != tc.expected {
+				t.Errorf("Expected limits %+v, but got %+v", tc.expected, limits)
+			}
+		})
+	}
+}
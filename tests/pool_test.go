@@ -0,0 +1,30 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+func TestPoolConfigValidation(t *testing.T) {
+	testCases := []struct {
+		name  string
+		sizes map[string]int
+	}{
+		{name: "UnknownLanguage", sizes: map[string]int{"cobol": 1}},
+		{name: "NegativeSize", sizes: map[string]int{"python": -1}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// Creating the client does not contact the daemon, so this
+			// runs without Docker
+			exec, err := executor.NewDockerExecutor(language.Default(), executor.WithPool(executor.PoolConfig{Sizes: tc.sizes}))
+			if err == nil {
+				exec.Close()
+				t.Fatal("Expected an error, but got none")
+			}
+		})
+	}
+}
@@ -0,0 +1,268 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/isavita/codeexec/cmd/api/server"
+	"github.com/isavita/codeexec/internal/auth"
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/executor/fake"
+	"github.com/isavita/codeexec/internal/language"
+	"github.com/isavita/codeexec/internal/sessions"
+)
+
+func TestSessionManager(t *testing.T) {
+	exec := fake.New().
+		On("x = 41", fake.Output("")).
+		On("x + 1", fake.Response{Result: fake.Output("").Result, Value: "42"}).
+		On("while True: pass", fake.Timeout())
+	request := executor.Request{Language: "python", Limits: language.Limits{Timeout: language.Duration(50 * time.Millisecond)}}
+
+	t.Run("State", func(t *testing.T) {
+		manager := sessions.New(exec, sessions.Config{})
+		defer manager.Close()
+
+		session, err := manager.Create(context.Background(), request, "")
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if session.ID == "" || session.Language != "python" || !session.ExpiresAt.After(session.CreatedAt) {
+			t.Errorf("Expected a python session with an ID and an expiry, but got %+v", session)
+		}
+		if _, err := manager.Execute(context.Background(), session.ID, "", "x = 41"); err != nil {
+			t.Fatalf("Failed to execute cell: %v", err)
+		}
+		result, err := manager.Execute(context.Background(), session.ID, "", "x + 1")
+		if err != nil {
+			t.Fatalf("Failed to execute cell: %v", err)
+		}
+		if result.Value != "42" {
+			t.Errorf("Expected the value of the last expression, but got %+v", result)
+		}
+
+		kernels := exec.Kernels()
+		kernel := kernels[len(kernels)-1]
+		if cells := kernel.Cells(); len(cells) != 2 {
+			t.Errorf("Expected both cells to run in the same kernel, but got %q", cells)
+		}
+		if session, _ := manager.Get(session.ID, ""); session.Cells != 2 {
+			t.Errorf("Expected the session to count 2 cells, but got %d", session.Cells)
+		}
+	})
+
+	t.Run("Owner", func(t *testing.T) {
+		manager := sessions.New(exec, sessions.Config{})
+		defer manager.Close()
+
+		session, _ := manager.Create(context.Background(), request, "editor")
+		if _, err := manager.Get(session.ID, "notebooks"); !errors.Is(err, sessions.ErrNotFound) {
+			t.Errorf("Expected another owner's session not to be found, but got %v", err)
+		}
+		if _, err := manager.Execute(context.Background(), session.ID, "notebooks", "x = 41"); !errors.Is(err, sessions.ErrNotFound) {
+			t.Errorf("Expected another owner's session not to run cells, but got %v", err)
+		}
+		if err := manager.Delete(session.ID, "notebooks"); !errors.Is(err, sessions.ErrNotFound) {
+			t.Errorf("Expected another owner's session not to be deleted, but got %v", err)
+		}
+	})
+
+	t.Run("KernelExited", func(t *testing.T) {
+		manager := sessions.New(exec, sessions.Config{})
+		defer manager.Close()
+
+		session, _ := manager.Create(context.Background(), request, "")
+		result, err := manager.Execute(context.Background(), session.ID, "", "while True: pass")
+		if err != nil {
+			t.Fatalf("Failed to execute cell: %v", err)
+		}
+		if !result.TimedOut || !result.KernelExited {
+			t.Errorf("Expected the cell to time out and take the kernel down, but got %+v", result)
+		}
+		if _, err := manager.Get(session.ID, ""); !errors.Is(err, sessions.ErrNotFound) {
+			t.Errorf("Expected the session to be deleted with its kernel, but got %v", err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		manager := sessions.New(exec, sessions.Config{})
+		defer manager.Close()
+
+		session, _ := manager.Create(context.Background(), request, "")
+		if err := manager.Delete(session.ID, ""); err != nil {
+			t.Fatalf("Failed to delete session: %v", err)
+		}
+		kernels := exec.Kernels()
+		if !kernels[len(kernels)-1].Closed() {
+			t.Error("Expected the session's kernel to be closed")
+		}
+		if _, err := manager.Execute(context.Background(), session.ID, "", "x + 1"); !errors.Is(err, sessions.ErrNotFound) {
+			t.Errorf("Expected the deleted session not to be found, but got %v", err)
+		}
+	})
+
+	t.Run("DeleteWhileRunning", func(t *testing.T) {
+		exec := fake.New().On("import time; time.sleep(1)", fake.Response{Result: fake.Output("").Result, Delay: time.Second})
+		manager := sessions.New(exec, sessions.Config{})
+		defer manager.Close()
+
+		session, _ := manager.Create(context.Background(), executor.Request{Language: "python"}, "")
+		done := make(chan error, 1)
+		go func() {
+			_, err := manager.Execute(context.Background(), session.ID, "", "import time; time.sleep(1)")
+			done <- err
+		}()
+		// Let the cell start
+		time.Sleep(100 * time.Millisecond)
+		if err := manager.Delete(session.ID, ""); err != nil {
+			t.Fatalf("Failed to delete session: %v", err)
+		}
+		if err := <-done; !errors.Is(err, sessions.ErrNotFound) {
+			t.Errorf("Expected the running cell to end with %v, but got %v", sessions.ErrNotFound, err)
+		}
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		exec := fake.New().On("import time; time.sleep(1)", fake.Response{Result: fake.Output("").Result, Delay: time.Second})
+		manager := sessions.New(exec, sessions.Config{MaxSessions: 1})
+		defer manager.Close()
+
+		session, _ := manager.Create(context.Background(), executor.Request{Language: "python"}, "")
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := manager.Execute(ctx, session.ID, "", "import time; time.sleep(1)")
+		if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, executor.ErrKernelExited) {
+			t.Errorf("Expected the cancelled cell to take the kernel down, but got %v", err)
+		}
+		if _, err := manager.Get(session.ID, ""); !errors.Is(err, sessions.ErrNotFound) {
+			t.Errorf("Expected the session to be deleted with its kernel, but got %v", err)
+		}
+		if !exec.Kernels()[0].Closed() {
+			t.Error("Expected the session's kernel to be closed")
+		}
+		if _, err := manager.Create(context.Background(), executor.Request{Language: "python"}, ""); err != nil {
+			t.Errorf("Expected the session's slot to be freed, but got %v", err)
+		}
+	})
+
+	t.Run("IdleTimeout", func(t *testing.T) {
+		manager := sessions.New(exec, sessions.Config{IdleTimeout: 50 * time.Millisecond})
+		defer manager.Close()
+
+		session, _ := manager.Create(context.Background(), request, "")
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if _, err := manager.Get(session.ID, ""); errors.Is(err, sessions.ErrNotFound) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("Expected the idle session to expire")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		kernels := exec.Kernels()
+		if !kernels[len(kernels)-1].Closed() {
+			t.Error("Expected the expired session's kernel to be closed")
+		}
+	})
+
+	t.Run("TooManySessions", func(t *testing.T) {
+		manager := sessions.New(exec, sessions.Config{MaxSessions: 1})
+		defer manager.Close()
+
+		if _, err := manager.Create(context.Background(), request, ""); err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if _, err := manager.Create(context.Background(), request, ""); !errors.Is(err, sessions.ErrTooManySessions) {
+			t.Errorf("Expected %v, but got %v", sessions.ErrTooManySessions, err)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		manager := sessions.New(exec, sessions.Config{})
+		manager.Create(context.Background(), request, "")
+		manager.Close()
+
+		kernels := exec.Kernels()
+		if !kernels[len(kernels)-1].Closed() {
+			t.Error("Expected the open sessions' kernels to be closed")
+		}
+		if _, err := manager.Create(context.Background(), request, ""); !errors.Is(err, sessions.ErrClosed) {
+			t.Errorf("Expected %v, but got %v", sessions.ErrClosed, err)
+		}
+	})
+}
+
+func TestSessionsEndpoints(t *testing.T) {
+	keys, err := auth.Parse([]byte(keysConfig))
+	if err != nil {
+		t.Fatalf("Failed to parse keys: %v", err)
+	}
+	exec := fake.New().On("x", fake.Response{Result: fake.Output("").Result, Value: "1"})
+	manager := sessions.New(exec, sessions.Config{})
+	defer manager.Close()
+	srv := server.NewServer(exec, language.Default(), server.WithAPIKeys(keys), server.WithSessions(manager))
+
+	serve := func(method, path, apiKey string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var reader bytes.Reader
+		if body != nil {
+			data, _ := json.Marshal(body)
+			reader.Reset(data)
+		}
+		req := httptest.NewRequest(method, path, &reader)
+		req.Header.Set("X-Api-Key", apiKey)
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := serve("POST", "/api/sessions", "editor-key", map[string]any{"language": "python", "files": map[string]string{"data.txt": "1"}})
+	if recorder.Code != http.StatusCreated {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusCreated, recorder.Code, recorder.Body)
+	}
+	var session sessions.Session
+	if err := json.Unmarshal(recorder.Body.Bytes(), &session); err != nil {
+		t.Fatalf("Failed to decode session: %v", err)
+	}
+	if location := recorder.Header().Get("Location"); location != "/api/sessions/"+session.ID {
+		t.Errorf("Expected the session's location, but got %q", location)
+	}
+	if requests := exec.Requests(); requests[len(requests)-1].Files["data.txt"] != "1" {
+		t.Errorf("Expected the session to be seeded with the files, but got %+v", requests[len(requests)-1])
+	}
+
+	recorder = serve("POST", "/api/sessions/"+session.ID+"/execute", "editor-key", map[string]any{"code": "x"})
+	var result executor.CellResult
+	if err := json.Unmarshal(recorder.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode cell result: %v", err)
+	}
+	if recorder.Code != http.StatusOK || result.Value != "1" {
+		t.Errorf("Expected the cell's value, but got status code %d and %+v", recorder.Code, result)
+	}
+
+	if recorder := serve("GET", "/api/sessions/"+session.ID, "editor-key", nil); recorder.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, but got %d", http.StatusOK, recorder.Code)
+	}
+	if recorder := serve("POST", "/api/sessions/"+session.ID+"/execute", "notebooks-key", map[string]any{"code": "x"}); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected another key's session not to be found, but got status code %d", recorder.Code)
+	}
+	if recorder := serve("POST", "/api/sessions/"+session.ID+"/execute", "editor-key", map[string]any{}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected a cell without code to be rejected, but got status code %d", recorder.Code)
+	}
+	if recorder := serve("POST", "/api/sessions", "editor-key", map[string]any{"language": "c"}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected a language without a kernel to be rejected, but got status code %d", recorder.Code)
+	}
+	if recorder := serve("DELETE", "/api/sessions/"+session.ID, "editor-key", nil); recorder.Code != http.StatusNoContent {
+		t.Errorf("Expected status code %d, but got %d", http.StatusNoContent, recorder.Code)
+	}
+	if recorder := serve("GET", "/api/sessions/"+session.ID, "editor-key", nil); recorder.Code != http.StatusNotFound {
+		t.Errorf("Expected the deleted session not to be found, but got status code %d", recorder.Code)
+	}
+}
@@ -0,0 +1,156 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/isavita/codeexec/internal/executor"
+	"github.com/isavita/codeexec/internal/executor/fake"
+	"github.com/isavita/codeexec/internal/handler"
+	"github.com/isavita/codeexec/internal/language"
+)
+
+// event is a Server-Sent Event.
+type event struct {
+	name string
+	data string
+}
+
+func parseEvents(t *testing.T, recorder *httptest.ResponseRecorder) []event {
+	t.Helper()
+	var events []event
+	var current event
+	scanner := bufio.NewScanner(recorder.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			events = append(events, current)
+			current = event{}
+		case strings.HasPrefix(line, "event: "):
+			current.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.data = strings.TrimPrefix(line, "data: ")
+		default:
+			t.Fatalf("Unexpected line in event stream: %q", line)
+		}
+	}
+	return events
+}
+
+// chunkedExecutor writes its chunks to stdout, then fails with err if set.
+type chunkedExecutor struct {
+	chunks []string
+	err    error
+}
+
+func (e *chunkedExecutor) Execute(ctx context.Context, req executor.Request) (*executor.Result, error) {
+	for _, chunk := range e.chunks {
+		req.Output(executor.Stdout, []byte(chunk))
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return &executor.Result{Stdout: strings.Join(e.chunks, ""), SyntaxCheck: executor.SyntaxCheck{Passed: true}}, nil
+}
+
+// streamedOutput concatenates the output events of a stream.
+func streamedOutput(t *testing.T, events []event, stream string) string {
+	t.Helper()
+	var output strings.Builder
+	for _, e := range events {
+		if e.name != stream {
+			continue
+		}
+		var data struct {
+			Data string `json:"data"`
+		}
+		if err := json.Unmarshal([]byte(e.data), &data); err != nil {
+			t.Fatalf("Failed to decode %s event: %v", stream, err)
+		}
+		output.WriteString(data.Data)
+	}
+	return output.String()
+}
+
+func TestStreamHandler(t *testing.T) {
+	t.Run("Output", func(t *testing.T) {
+		exec := fake.New().On("print('hi')", fake.Response{Result: executor.Result{
+			Stdout:      "hi\n",
+			Stderr:      "warning\n",
+			ExitCode:    3,
+			SyntaxCheck: executor.SyntaxCheck{Passed: true},
+		}})
+		h := handler.NewStreamHandler(exec, language.Default())
+
+		recorder := serveExecute(t, h, map[string]string{"code": "print('hi')", "language": "python"})
+		if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("Expected an event stream, but got status code %d and %q", recorder.Code, recorder.Header().Get("Content-Type"))
+		}
+		events := parseEvents(t, recorder)
+		if len(events) != 3 || events[2].name != "result" {
+			t.Fatalf("Expected stdout, stderr and result events, but got %+v", events)
+		}
+		if stdout, stderr := streamedOutput(t, events, "stdout"), streamedOutput(t, events, "stderr"); stdout != "hi\n" || stderr != "warning\n" {
+			t.Errorf("Expected the program's output, but got %q and %q", stdout, stderr)
+		}
+		var result executor.Result
+		if err := json.Unmarshal([]byte(events[2].data), &result); err != nil {
+			t.Fatalf("Failed to decode result event: %v", err)
+		}
+		if result.ExitCode != 3 || result.Stdout != "hi\n" {
+			t.Errorf("Expected the execution result, but got %+v", result)
+		}
+	})
+
+	t.Run("SplitCharacters", func(t *testing.T) {
+		// "é" is split across chunks
+		exec := &chunkedExecutor{chunks: []string{"caf\xc3", "\xa9\n"}}
+		h := handler.NewStreamHandler(exec, language.Default())
+
+		events := parseEvents(t, serveExecute(t, h, map[string]string{"code": "print('café')", "language": "python"}))
+		for _, e := range events {
+			if strings.ContainsRune(e.data, utf8.RuneError) {
+				t.Errorf("Expected characters to be reassembled, but got %s", e.data)
+			}
+		}
+		if stdout := streamedOutput(t, events, "stdout"); stdout != "café\n" {
+			t.Errorf("Expected output %q, but got %q", "café\n", stdout)
+		}
+	})
+
+	t.Run("ErrorBeforeOutput", func(t *testing.T) {
+		exec := fake.New().Default(fake.Error(errors.New("daemon unavailable")))
+		h := handler.NewStreamHandler(exec, language.Default())
+
+		recorder := serveExecute(t, h, map[string]string{"code": "print('hi')", "language": "python"})
+		if recorder.Code != http.StatusInternalServerError || !strings.Contains(recorder.Body.String(), "daemon unavailable") {
+			t.Errorf("Expected a plain error response, but got status code %d: %s", recorder.Code, recorder.Body)
+		}
+	})
+
+	t.Run("ErrorAfterOutput", func(t *testing.T) {
+		exec := &chunkedExecutor{chunks: []string{"partial\n"}, err: errors.New("daemon unavailable")}
+		h := handler.NewStreamHandler(exec, language.Default())
+
+		events := parseEvents(t, serveExecute(t, h, map[string]string{"code": "print('hi')", "language": "python"}))
+		if len(events) != 2 || events[1].name != "error" || !strings.Contains(events[1].data, "daemon unavailable") {
+			t.Errorf("Expected the output followed by an error event, but got %+v", events)
+		}
+	})
+
+	t.Run("InvalidRequest", func(t *testing.T) {
+		h := handler.NewStreamHandler(fake.New(), language.Default())
+		recorder := serveExecute(t, h, map[string]string{"code": "print('hi')"})
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("Expected status code %d, but got %d", http.StatusBadRequest, recorder.Code)
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/isavita/codeexec/cmd/api/server"
+	"github.com/isavita/codeexec/internal/executor/fake"
+	"github.com/isavita/codeexec/internal/jobs"
+	"github.com/isavita/codeexec/internal/language"
+	"github.com/isavita/codeexec/internal/webhook"
+)
+
+var webhookSecret = []byte("webhook-secret")
+
+// delivery is a request received by a webhookReceiver.
+type delivery struct {
+	header http.Header
+	body   []byte
+}
+
+// webhookReceiver records the deliveries it receives, failing the first
+// failures of them with a 500 status.
+type webhookReceiver struct {
+	*httptest.Server
+	mu         sync.Mutex
+	failures   int
+	deliveries []delivery
+	received   chan struct{}
+}
+
+func newWebhookReceiver(t *testing.T, failures int) *webhookReceiver {
+	r := &webhookReceiver{failures: failures, received: make(chan struct{}, 100)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.deliveries = append(r.deliveries, delivery{header: req.Header, body: body})
+		fail := len(r.deliveries) <= r.failures
+		r.mu.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		r.received <- struct{}{}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *webhookReceiver) Deliveries() []delivery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]delivery(nil), r.deliveries...)
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	sender := webhook.New(webhook.Config{Secret: webhookSecret, MaxAttempts: 3, Backoff: time.Millisecond, AllowPrivate: true})
+	payload := []byte(`{"id":"42"}`)
+
+	t.Run("Retries", func(t *testing.T) {
+		receiver := newWebhookReceiver(t, 2)
+		var attempts []webhook.Attempt
+		err := sender.Deliver(context.Background(), receiver.URL, payload, func(attempt webhook.Attempt) {
+			attempts = append(attempts, attempt)
+		})
+		if err != nil {
+			t.Fatalf("Expected the third attempt to succeed, but got %v", err)
+		}
+
+		if len(attempts) != 3 || attempts[0].StatusCode != 500 || attempts[0].Succeeded() || !attempts[2].Succeeded() {
+			t.Errorf("Expected two failed attempts and a successful one, but got %+v", attempts)
+		}
+		deliveries := receiver.Deliveries()
+		if len(deliveries) != 3 {
+			t.Fatalf("Expected 3 deliveries, but got %d", len(deliveries))
+		}
+		id := deliveries[0].header.Get(webhook.DeliveryHeader)
+		for _, d := range deliveries {
+			if !bytes.Equal(d.body, payload) {
+				t.Errorf("Expected payload %s, but got %s", payload, d.body)
+			}
+			if err := webhook.Verify(webhookSecret, d.header, d.body); err != nil {
+				t.Errorf("Expected a valid signature, but got %v", err)
+			}
+			if d.header.Get(webhook.DeliveryHeader) != id {
+				t.Errorf("Expected retries to keep delivery ID %s, but got %s", id, d.header.Get(webhook.DeliveryHeader))
+			}
+		}
+		if err := webhook.Verify([]byte("other-secret"), deliveries[0].header, payload); err == nil {
+			t.Error("Expected a signature made with another secret to be rejected")
+		}
+	})
+
+	t.Run("GivesUp", func(t *testing.T) {
+		receiver := newWebhookReceiver(t, 10)
+		attempts := 0
+		err := sender.Deliver(context.Background(), receiver.URL, payload, func(webhook.Attempt) { attempts++ })
+		if err == nil {
+			t.Fatal("Expected the delivery to fail")
+		}
+		if attempts != 3 || len(receiver.Deliveries()) != 3 {
+			t.Errorf("Expected 3 attempts, but got %d and %d deliveries", attempts, len(receiver.Deliveries()))
+		}
+	})
+}
+
+func TestJobCallback(t *testing.T) {
+	receiver := newWebhookReceiver(t, 1)
+	exec := fake.New().On("print('hi')", fake.Output("hi\n"))
+	queue := jobs.New(exec, jobs.Config{
+		Workers:  1,
+		Webhooks: webhook.Config{Secret: webhookSecret, Backoff: time.Millisecond, AllowPrivate: true},
+	})
+	defer queue.Close()
+	srv := server.NewServer(exec, language.Default(), server.WithJobs(queue))
+
+	submit := func(path string, body map[string]any) *httptest.ResponseRecorder {
+		data, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(data))
+		recorder := httptest.NewRecorder()
+		srv.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := submit("/api/jobs", map[string]any{"code": "print('hi')", "language": "python", "callback_url": receiver.URL})
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("Expected status code %d, but got %d: %s", http.StatusAccepted, recorder.Code, recorder.Body)
+	}
+	var job jobs.Job
+	json.Unmarshal(recorder.Body.Bytes(), &job)
+	if job.Callback == nil || job.Callback.URL != receiver.URL || job.Callback.Status != jobs.CallbackPending {
+		t.Errorf("Expected a pending callback, but got %+v", job.Callback)
+	}
+
+	for range 2 {
+		select {
+		case <-receiver.received:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the callback")
+		}
+	}
+	deliveries := receiver.Deliveries()
+	last := deliveries[len(deliveries)-1]
+	if err := webhook.Verify(webhookSecret, last.header, last.body); err != nil {
+		t.Errorf("Expected a valid signature, but got %v", err)
+	}
+	var delivered jobs.Job
+	if err := json.Unmarshal(last.body, &delivered); err != nil {
+		t.Fatalf("Failed to decode the callback payload: %v", err)
+	}
+	if delivered.ID != job.ID || delivered.Status != jobs.StatusSucceeded || delivered.Result == nil || delivered.Result.Stdout != "hi\n" {
+		t.Errorf("Expected the finished job as payload, but got %+v", delivered)
+	}
+
+	var callback *jobs.Callback
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		job, _ := queue.Get(job.ID, "")
+		if callback = job.Callback; callback.Status != jobs.CallbackPending {
+			break
+		}
+	}
+	if callback.Status != jobs.CallbackDelivered || len(callback.Attempts) != 2 || callback.Attempts[0].StatusCode != 500 {
+		t.Errorf("Expected the delivery log to show a retried delivery, but got %+v", callback)
+	}
+
+	if recorder := submit("/api/jobs", map[string]any{"code": "print('hi')", "language": "python", "callback_url": "ftp://example.com"}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an invalid callback_url, but got %d", http.StatusBadRequest, recorder.Code)
+	}
+	if recorder := submit("/api/execute", map[string]any{"code": "print('hi')", "language": "python", "callback_url": receiver.URL}); recorder.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for a synchronous callback_url, but got %d", http.StatusBadRequest, recorder.Code)
+	}
+}
+
+func TestWebhookPrivateAddresses(t *testing.T) {
+	receiver := newWebhookReceiver(t, 0)
+	sender := webhook.New(webhook.Config{MaxAttempts: 1})
+
+	for _, rawURL := range []string{receiver.URL, "http://localhost:2375/containers/json", "http://169.254.169.254/latest/meta-data/", "http://10.0.0.1/", "http://[::1]/"} {
+		if err := sender.ValidateURL(rawURL); err == nil {
+			t.Errorf("Expected %s to be rejected", rawURL)
+		}
+	}
+	if err := sender.ValidateURL("https://example.com/hook"); err != nil {
+		t.Errorf("Expected a public URL to be accepted, but got %v", err)
+	}
+
+	// A name resolving to a private address is refused when dialed
+	hostURL := strings.Replace(receiver.URL, "127.0.0.1", "localhost", 1)
+	if err := sender.Deliver(context.Background(), hostURL, []byte(`{}`), func(webhook.Attempt) {}); err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Errorf("Expected the delivery to a private address to be refused, but got %v", err)
+	}
+	if len(receiver.Deliveries()) != 0 {
+		t.Error("Expected the receiver not to be reached")
+	}
+
+	allowing := webhook.New(webhook.Config{MaxAttempts: 1, AllowPrivate: true})
+	if err := allowing.ValidateURL(receiver.URL); err != nil {
+		t.Errorf("Expected private addresses to be allowed, but got %v", err)
+	}
+	if err := allowing.Deliver(context.Background(), receiver.URL, []byte(`{}`), func(webhook.Attempt) {}); err != nil {
+		t.Errorf("Expected the delivery to succeed, but got %v", err)
+	}
+}
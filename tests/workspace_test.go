@@ -0,0 +1,205 @@
+package tests
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/isavita/codeexec/internal/executor"
+)
+
+func zipArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("Failed to add %s to zip: %v", name, err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("Failed to write zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func tarGzArchive(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatalf("Failed to add %s to tar: %v", name, err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to write tar: %v", err)
+	}
+	gz.Close()
+	return buf.Bytes()
+}
+
+func readWorkspaceFile(t *testing.T, dir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", name, err)
+	}
+	return string(data)
+}
+
+func TestMaterializeWorkspace(t *testing.T) {
+	t.Run("CodeOnly", func(t *testing.T) {
+		dir := t.TempDir()
+		entrypoint, err := executor.MaterializeWorkspace(dir, "code.py", executor.Request{Code: "print(1)"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if entrypoint != "code.py" {
+			t.Errorf("Expected entrypoint code.py, but got %s", entrypoint)
+		}
+		if got := readWorkspaceFile(t, dir, "code.py"); got != "print(1)" {
+			t.Errorf("Unexpected code file content: %q", got)
+		}
+	})
+
+	t.Run("FilesWithEntrypoint", func(t *testing.T) {
+		dir := t.TempDir()
+		entrypoint, err := executor.MaterializeWorkspace(dir, "code.py", executor.Request{
+			Files: map[string]string{
+				"app/main.py":    "import helpers",
+				"app/helpers.py": "X = 1",
+				"data/input.txt": "42",
+			},
+			Entrypoint: "./app/main.py",
+		})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if entrypoint != "app/main.py" {
+			t.Errorf("Expected entrypoint app/main.py, but got %s", entrypoint)
+		}
+		if got := readWorkspaceFile(t, dir, "data/input.txt"); got != "42" {
+			t.Errorf("Unexpected data file content: %q", got)
+		}
+	})
+
+	t.Run("ZipArchiveWithCodeOverride", func(t *testing.T) {
+		dir := t.TempDir()
+		archive := zipArchive(t, map[string]string{
+			"code.py":   "print('from archive')",
+			"lib/a.py":  "A = 1",
+			"README.md": "docs",
+		})
+		_, err := executor.MaterializeWorkspace(dir, "code.py", executor.Request{Code: "print('from code')", Archive: archive})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got := readWorkspaceFile(t, dir, "code.py"); got != "print('from code')" {
+			t.Errorf("Expected code to override the archive, but got %q", got)
+		}
+		if got := readWorkspaceFile(t, dir, "lib/a.py"); got != "A = 1" {
+			t.Errorf("Unexpected archive file content: %q", got)
+		}
+	})
+
+	t.Run("TarGzArchive", func(t *testing.T) {
+		dir := t.TempDir()
+		archive := tarGzArchive(t, map[string]string{"src/main.js": "console.log(1)"})
+		entrypoint, err := executor.MaterializeWorkspace(dir, "code.js", executor.Request{Archive: archive, Entrypoint: "src/main.js"})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got := readWorkspaceFile(t, dir, entrypoint); got != "console.log(1)" {
+			t.Errorf("Unexpected entrypoint content: %q", got)
+		}
+	})
+}
+
+func TestMaterializeWorkspaceRejectsInvalidRequests(t *testing.T) {
+	testCases := []struct {
+		name string
+		req  func(t *testing.T) executor.Request
+		err  string
+	}{
+		{
+			name: "Empty",
+			req:  func(t *testing.T) executor.Request { return executor.Request{} },
+			err:  "no code, files or archive provided",
+		},
+		{
+			name: "ParentDirectoryFile",
+			req: func(t *testing.T) executor.Request {
+				return executor.Request{Files: map[string]string{"../escape.py": "x"}}
+			},
+			err: "invalid file path",
+		},
+		{
+			name: "AbsoluteFile",
+			req: func(t *testing.T) executor.Request {
+				return executor.Request{Files: map[string]string{"/etc/passwd": "x"}}
+			},
+			err: "invalid file path",
+		},
+		{
+			name: "EscapingEntrypoint",
+			req: func(t *testing.T) executor.Request {
+				return executor.Request{Code: "print(1)", Entrypoint: "a/../../main.py"}
+			},
+			err: "invalid file path",
+		},
+		{
+			name: "ZipSlip",
+			req: func(t *testing.T) executor.Request {
+				return executor.Request{Archive: zipArchive(t, map[string]string{"../../evil.py": "x"})}
+			},
+			err: "invalid file path",
+		},
+		{
+			name: "MissingEntrypoint",
+			req: func(t *testing.T) executor.Request {
+				return executor.Request{Files: map[string]string{"helpers.py": "x"}, Entrypoint: "main.py"}
+			},
+			err: "entrypoint main.py not found",
+		},
+		{
+			name: "TooLarge",
+			req: func(t *testing.T) executor.Request {
+				return executor.Request{Archive: zipArchive(t, map[string]string{"code.py": strings.Repeat("x", 33<<20)})}
+			},
+			err: "files exceed",
+		},
+		{
+			name: "CorruptArchive",
+			req: func(t *testing.T) executor.Request {
+				return executor.Request{Archive: []byte("PK\x03\x04garbage")}
+			},
+			err: "invalid archive",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := executor.MaterializeWorkspace(t.TempDir(), "code.py", tc.req(t))
+			if err == nil {
+				t.Fatalf("Expected an error containing '%s', but got nil", tc.err)
+			}
+			if !errors.Is(err, executor.ErrInvalidRequest) {
+				t.Errorf("Expected an invalid request error, but got: %v", err)
+			}
+			if !strings.Contains(err.Error(), tc.err) {
+				t.Errorf("Expected an error containing '%s', but got: %v", tc.err, err)
+			}
+		})
+	}
+}